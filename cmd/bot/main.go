@@ -1,14 +1,18 @@
 package main
 
 import (
+	"context"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 
 	"hafton-movie-bot/internal/bot"
 	"hafton-movie-bot/internal/config"
 	"hafton-movie-bot/internal/database"
+	"hafton-movie-bot/internal/metrics"
 	"hafton-movie-bot/internal/storage"
 )
 
@@ -24,7 +28,6 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
-	defer db.Close()
 
 	// Ensure database directory exists
 	if err := os.MkdirAll("data", 0755); err != nil {
@@ -53,18 +56,43 @@ func main() {
 		log.Fatalf("Failed to create bot: %v", err)
 	}
 
-	// Handle graceful shutdown
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	// Stop polling on SIGINT/SIGTERM and let in-flight messages finish
+	// before the database is closed
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var wg sync.WaitGroup
 
+	// The bot has no HTTP server of its own, so its metrics can only be
+	// served on the separate metrics address
+	var metricsServer *http.Server
+	if m := cfg.Metrics; m.Enabled {
+		if m.Listen != "" {
+			metricsServer = metrics.Listen(m.Listen, m.Token)
+		} else {
+			log.Println("Warning: metrics enabled without metrics.listen, the bot doesn't expose /metrics")
+		}
+	}
+
+	wg.Add(1)
 	go func() {
-		if err := telegramBot.Start(); err != nil {
+		defer wg.Done()
+		if err := telegramBot.Start(ctx); err != nil {
 			log.Fatalf("Bot error: %v", err)
 		}
 	}()
 
 	log.Println("Bot is running. Press Ctrl+C to stop.")
-	<-sigChan
+	<-ctx.Done()
 	log.Println("Shutting down...")
+	wg.Wait()
+	if metricsServer != nil {
+		metricsServer.Close()
+	}
+
+	// Close the database last, once nothing can use it any more
+	if err := db.Close(); err != nil {
+		log.Printf("Error closing database: %v", err)
+	}
+	log.Println("Shutdown complete")
 }
 
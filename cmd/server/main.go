@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
 	"os/signal"
 	"strconv"
+	"sync"
 	"syscall"
 	"time"
 
@@ -23,11 +25,12 @@ func main() {
 	}
 	
 	cfg, err := config.Load(configPath)
+	fromFile := err == nil
 	if err != nil {
 		// If config file doesn't exist, create default config from environment
 		log.Printf("Config file not found, using environment variables: %v", err)
 		cfg = &config.Config{}
-		cfg.Telegram.BotToken = os.Getenv("TELEGRAM_BOT_TOKEN")
+		cfg.LoadTelegramEnv()
 		if cfg.Telegram.BotToken == "" {
 			log.Fatalf("TELEGRAM_BOT_TOKEN environment variable is required")
 		}
@@ -66,7 +69,6 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
-	defer db.Close()
 
 	// Ensure database directory exists
 	if err := os.MkdirAll("data", 0755); err != nil {
@@ -79,6 +81,15 @@ func main() {
 		log.Fatalf("Failed to initialize storage: %v", err)
 	}
 
+	// Enable read-through disk cache for proxied files
+	if cfg.Cache.Enabled {
+		chunkSize := int64(cfg.Cache.ChunkSizeKB) * 1024
+		maxSize := int64(cfg.Cache.MaxSizeMB) * 1024 * 1024
+		if err := storage.EnableCache(chunkSize, maxSize); err != nil {
+			log.Fatalf("Failed to initialize cache: %v", err)
+		}
+	}
+
 	// Get domain from config or environment
 	domain := cfg.Server.Domain
 	if domain == "" {
@@ -92,22 +103,62 @@ func main() {
 	// Create and start server
 	httpServer := server.New(cfg, db, storage, domain)
 
+	// Everything stops on SIGINT/SIGTERM; the database is closed once the
+	// server and cleanup have finished
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var wg sync.WaitGroup
+
 	// Start cleanup goroutine (runs every hour)
 	cleanup := cleanup.New(db, storage, time.Hour)
-	go cleanup.Start()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		cleanup.Start(ctx)
+	}()
+
+	// On SIGHUP reload runtime-adjustable settings (bandwidth limits), reopen
+	// the access log and reload the TLS certificate. Settings can only be
+	// reloaded from a config file; environment variables are fixed for the
+	// life of the process.
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	go func() {
+		for range hupChan {
+			httpServer.ReopenLogs()
+			httpServer.ReloadTLS()
 
-	// Handle graceful shutdown
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+			if !fromFile {
+				log.Printf("Started from environment variables, settings are only reloaded from a config file (%s)", configPath)
+				continue
+			}
+			newCfg, err := config.Load(configPath)
+			if err != nil {
+				log.Printf("Failed to reload config: %v", err)
+				continue
+			}
+			log.Println("Reloaded config")
+			httpServer.UpdateBandwidth(newCfg)
+		}
+	}()
 
+	wg.Add(1)
 	go func() {
-		if err := httpServer.Start(); err != nil {
+		defer wg.Done()
+		if err := httpServer.Start(ctx); err != nil {
 			log.Fatalf("Server error: %v", err)
 		}
 	}()
 
 	log.Println("HTTP server is running. Press Ctrl+C to stop.")
-	<-sigChan
+	<-ctx.Done()
 	log.Println("Shutting down...")
+	wg.Wait()
+
+	// Close the database last, once nothing can use it any more
+	if err := db.Close(); err != nil {
+		log.Printf("Error closing database: %v", err)
+	}
+	log.Println("Shutdown complete")
 }
 
@@ -0,0 +1,121 @@
+package accesslog
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// File is an append-only log file that rotates itself once it grows past
+// maxSize and can be reopened after external rotation (e.g. logrotate)
+type File struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64 // 0 = never rotate by size
+	maxBackups int   // Rotated files kept as path.1 ... path.N
+	file       *os.File
+	size       int64
+}
+
+// Open opens path for appending. An empty path or "-" writes to stdout.
+func Open(path string, maxSize int64, maxBackups int) (*File, error) {
+	f := &File{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if f.isStdout() {
+		return f, nil
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *File) isStdout() bool {
+	return f.path == "" || f.path == "-"
+}
+
+func (f *File) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open access log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat access log: %w", err)
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// Write appends one entry, rotating first if it would exceed the size limit
+func (f *File) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.isStdout() {
+		return os.Stdout.Write(p)
+	}
+	if f.file == nil {
+		return 0, io.ErrClosedPipe
+	}
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate shifts path -> path.1 -> path.2 ..., dropping the oldest backup
+func (f *File) rotate() error {
+	f.file.Close()
+	f.file = nil
+
+	if f.maxBackups > 0 {
+		os.Remove(fmt.Sprintf("%s.%d", f.path, f.maxBackups))
+		for i := f.maxBackups - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+		}
+		if err := os.Rename(f.path, f.path+".1"); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to rotate access log: %w", err)
+		}
+	} else if err := os.Truncate(f.path, 0); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to truncate access log: %w", err)
+	}
+
+	return f.open()
+}
+
+// Reopen closes and reopens the file so entries go to a fresh file after it
+// has been moved away
+func (f *File) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.isStdout() {
+		return nil
+	}
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+	return f.open()
+}
+
+// Close closes the underlying file
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
@@ -1,186 +1,44 @@
 package bot
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"log"
 	"mime"
 	"net/http"
-	"net/url"
+	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
+	"hafton-movie-bot/internal/botapi"
 	"hafton-movie-bot/internal/config"
 	"hafton-movie-bot/internal/database"
+	"hafton-movie-bot/internal/metrics"
 	"hafton-movie-bot/internal/storage"
+	"hafton-movie-bot/internal/subtitles"
 	"hafton-movie-bot/internal/utils"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
-// urlFixerClient wraps an HTTP client to fix malformed URLs from the telegram-bot-api library
-type urlFixerClient struct {
-	baseClient *http.Client
-	baseURL    string
-	token      string
-}
-
-func (c *urlFixerClient) Do(req *http.Request) (*http.Response, error) {
-	// ALWAYS rewrite the URL to use our custom Bot API server
-	// The library constructs paths like: /bot{token}/{method}
-	// We need to rewrite to: {baseURL}/bot{token}/{method}
-	
-	// Log the original request for debugging
-	log.Printf("Intercepting request: %s %s", req.Method, req.URL.String())
-	
-	path := req.URL.Path
-	
-	// Extract method from path
-	// Format is usually: /bot{token}/{method}
-	method := ""
-	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
-	if len(parts) >= 2 {
-		// Format: bot{token}/{method} - get the method (last part)
-		method = parts[len(parts)-1]
-	} else if len(parts) == 1 && parts[0] != "" && !strings.HasPrefix(parts[0], "bot") {
-		// Just the method
-		method = parts[0]
-	}
-	
-	// Reconstruct proper URL
-	// Bot API server expects: https://server.com:8081/bot{token}/{method}
-	// But Render routes to port 10000, so we need to check if port is needed
-	if method != "" {
-		// Try with port 8081 first (Bot API server's internal port)
-		// But Render might route to 10000, so we'll try without port first
-		fixedURL := fmt.Sprintf("%s/bot%s/%s", c.baseURL, c.token, method)
-		parsedURL, err := url.Parse(fixedURL)
-		if err == nil {
-			req.URL = parsedURL
-			log.Printf("Rewrote URL: %s -> %s", path, fixedURL)
-		} else {
-			log.Printf("Failed to parse fixed URL %s: %v", fixedURL, err)
-			return nil, fmt.Errorf("failed to construct URL: %w", err)
-		}
-	} else {
-		// Fallback: use base URL + original path
-		fixedURL := c.baseURL + path
-		parsedURL, err := url.Parse(fixedURL)
-		if err == nil {
-			req.URL = parsedURL
-			log.Printf("Rewrote URL (fallback): %s -> %s", path, fixedURL)
-		} else {
-			log.Printf("Failed to parse fallback URL %s: %v", fixedURL, err)
-			return nil, fmt.Errorf("failed to construct fallback URL: %w", err)
-		}
-	}
-	
-	// Log the response to debug what we're getting back
-	// We'll add this after the request
-	
-	// Make the request
-	resp, err := c.baseClient.Do(req)
-	if err != nil {
-		return resp, err
-	}
-	
-	// Log ALL responses for debugging (not just errors)
-	log.Printf("Bot API server returned status %d for %s", resp.StatusCode, req.URL.String())
-	
-	// Always log response body preview to debug issues
-	if resp.Body != nil {
-		// Read first 500 chars to see what we're getting
-		bodyBytes := make([]byte, 500)
-		n, _ := resp.Body.Read(bodyBytes)
-		if n > 0 {
-			preview := string(bodyBytes[:n])
-			// Check if it's HTML (error page) or JSON
-			if strings.HasPrefix(strings.TrimSpace(preview), "<") {
-				log.Printf("⚠️ Got HTML response (error page): %s", preview)
-			} else {
-				log.Printf("✅ Got JSON response preview: %s", preview)
-			}
-		}
-		// Create a new reader that includes the bytes we already read
-		resp.Body = io.NopCloser(io.MultiReader(strings.NewReader(string(bodyBytes[:n])), resp.Body))
-	}
-	
-	return resp, err
-}
-
 type Bot struct {
 	api      *tgbotapi.BotAPI
 	db       *database.DB
 	storage  *storage.Storage
 	config   *config.Config
 	domain   string
+
+	// In-flight handleMessage calls, waited for on shutdown
+	handlers sync.WaitGroup
 }
 
 func New(cfg *config.Config, db *database.DB, storage *storage.Storage, domain string) (*Bot, error) {
-	var api *tgbotapi.BotAPI
-	var err error
-	
-	// Use custom Bot API server if configured (for large file support)
-	if cfg.Telegram.BotAPIURL != "" {
-		// Clean up URL - remove trailing slashes and ensure proper format
-		apiEndpoint := strings.TrimSuffix(cfg.Telegram.BotAPIURL, "/")
-		// Ensure URL is valid
-		if !strings.HasPrefix(apiEndpoint, "http://") && !strings.HasPrefix(apiEndpoint, "https://") {
-			return nil, fmt.Errorf("Bot API URL must start with http:// or https://")
-		}
-		
-		// If Bot API URL is set, try localhost first (Bot API runs in same container)
-		// This bypasses all networking issues!
-		originalEndpoint := apiEndpoint
-		
-		if strings.Contains(apiEndpoint, ".onrender.com") || strings.Contains(apiEndpoint, "hafton-streamer-2") {
-			// External URL or service name detected - use localhost instead
-			// Bot API server runs in same container on port 8081
-			localhostURL := "http://localhost:8081"
-			log.Printf("🔄 Converting %s to localhost: %s (Bot API in same container)", originalEndpoint, localhostURL)
-			apiEndpoint = localhostURL
-		} else if strings.Contains(apiEndpoint, "localhost") || strings.Contains(apiEndpoint, "127.0.0.1") {
-			// Already localhost - perfect!
-			log.Printf("✅ Using localhost URL: %s (Bot API in same container)", apiEndpoint)
-		} else if !strings.Contains(apiEndpoint, ".onrender.com") && strings.Contains(apiEndpoint, ":") {
-			// Internal URL format
-			log.Printf("ℹ️ Using provided internal URL: %s", apiEndpoint)
-		}
-		
-		log.Printf("Using custom Bot API server: %s", apiEndpoint)
-		
-		// Create a custom HTTP client that intercepts requests and fixes URLs
-		// This works around a bug in the library's URL construction
-		baseClient := &http.Client{
-			Timeout: 30 * time.Second,
-		}
-		
-		// Wrap the client to intercept and fix URLs
-		client := &urlFixerClient{
-			baseClient: baseClient,
-			baseURL:    apiEndpoint,
-			token:      cfg.Telegram.BotToken,
-		}
-		
-		// DON'T use SetAPIEndpoint or NewBotAPIWithAPIEndpoint - they have bugs
-		// Instead, create bot with default API and override the client
-		// The client will intercept and rewrite all URLs
-		api, err = tgbotapi.NewBotAPI(cfg.Telegram.BotToken)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create bot API: %w", err)
-		}
-		
-		// Set custom client with URL fixer - this will rewrite all URLs
-		api.Client = client
-		
-		log.Printf("Using custom Bot API server: %s (URLs will be rewritten by client)", apiEndpoint)
-	} else {
-		// Use default Telegram Bot API
-		api, err = tgbotapi.NewBotAPI(cfg.Telegram.BotToken)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create bot API: %w", err)
-		}
+	api, err := botapi.New(cfg)
+	if err != nil {
+		return nil, err
 	}
 
 	bot := &Bot{
@@ -194,7 +52,9 @@ func New(cfg *config.Config, db *database.DB, storage *storage.Storage, domain s
 	return bot, nil
 }
 
-func (b *Bot) Start() error {
+// Start polls for updates until ctx is cancelled, then stops polling and waits
+// for messages that are still being handled
+func (b *Bot) Start(ctx context.Context) error {
 	log.Printf("Authorized on account %s", b.api.Self.UserName)
 
 	u := tgbotapi.NewUpdate(0)
@@ -202,18 +62,44 @@ func (b *Bot) Start() error {
 
 	updates := b.api.GetUpdatesChan(u)
 
-	for update := range updates {
-		if update.Message == nil {
-			continue
-		}
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("Stopping Telegram bot, waiting for in-flight messages")
+			b.api.StopReceivingUpdates()
+			b.handlers.Wait()
+			return nil
+		case update, ok := <-updates:
+			if !ok {
+				b.handlers.Wait()
+				return nil
+			}
+			if update.Message == nil {
+				continue
+			}
 
-		go b.handleMessage(update.Message)
+			b.handlers.Add(1)
+			go func(msg *tgbotapi.Message) {
+				defer b.handlers.Done()
+				b.handleMessage(msg)
+			}(update.Message)
+		}
 	}
-
-	return nil
 }
 
 func (b *Bot) handleMessage(msg *tgbotapi.Message) {
+	if msg.IsCommand() {
+		b.handleCommand(msg)
+		return
+	}
+
+	// Subtitles are attached to their video instead of getting their own link
+	if msg.Document != nil && subtitles.DetectFormat(msg.Document.FileName) != "" {
+		if b.handleSubtitle(msg) {
+			return
+		}
+	}
+
 	// Handle forwarded messages - check original message for files
 	actualMsg := msg
 	if msg.ForwardFrom != nil || msg.ForwardFromChat != nil {
@@ -226,13 +112,16 @@ func (b *Bot) handleMessage(msg *tgbotapi.Message) {
 	var fileName string
 	var fileSize int64
 	var fileType string
+	var mediaType string
 
 	if actualMsg.Document != nil {
+		mediaType = "document"
 		telegramFileID = actualMsg.Document.FileID
 		fileName = actualMsg.Document.FileName
 		fileSize = int64(actualMsg.Document.FileSize)
 		fileType = actualMsg.Document.MimeType
 	} else if actualMsg.Video != nil {
+		mediaType = "video"
 		telegramFileID = actualMsg.Video.FileID
 		fileName = actualMsg.Video.FileName
 		if fileName == "" {
@@ -244,6 +133,7 @@ func (b *Bot) handleMessage(msg *tgbotapi.Message) {
 			fileType = "video/mp4"
 		}
 	} else if actualMsg.Audio != nil {
+		mediaType = "audio"
 		telegramFileID = actualMsg.Audio.FileID
 		fileName = actualMsg.Audio.FileName
 		if fileName == "" {
@@ -269,10 +159,11 @@ func (b *Bot) handleMessage(msg *tgbotapi.Message) {
 	// Get Telegram file info - try GetFile first
 	// For large files (>50MB), GetFile may fail, so we'll construct URL manually
 	var telegramFileURL string
+	var localPath string
 	file, err := b.api.GetFile(tgbotapi.FileConfig{FileID: telegramFileID})
 	if err != nil {
-		// Check if error is about file being too big
-		if strings.Contains(err.Error(), "too big") || strings.Contains(err.Error(), "file is too big") {
+		// Check if error is about file being too big (a local Bot API server has no such limit)
+		if !b.config.Telegram.LocalMode && (strings.Contains(err.Error(), "too big") || strings.Contains(err.Error(), "file is too big")) {
 			// For large files (>50MB), Telegram's GetFile API fails
 			// Unfortunately, we can't get the file_path without GetFile
 			// We'll store the file_id and construct a URL that might work
@@ -293,6 +184,9 @@ func (b *Bot) handleMessage(msg *tgbotapi.Message) {
 			b.sendError(msg.Chat.ID, fmt.Sprintf("Failed to get file info: %v", err))
 			return
 		}
+	} else if path, ok := botapi.LocalFilePath(b.config, file.FilePath); ok {
+		// A local Bot API server has already downloaded the file to its disk
+		localPath = path
 	} else {
 		// Get Telegram file URL from GetFile response (works for files <50MB)
 		telegramFileURL = file.Link(b.api.Token)
@@ -323,17 +217,41 @@ func (b *Bot) handleMessage(msg *tgbotapi.Message) {
 	record := &database.FileRecord{
 		ID:              fileID,
 		TelegramFileID:  telegramFileID,
-		TelegramFileURL: telegramFileURL,
-		FilePath:        "", // Not needed for proxied files
+		SourceKind:      database.SourceTelegram, // Always proxy - instant response
+		SourceLocator:   telegramFileURL,
 		FileName:        fileName,
 		FileSize:        fileSize,
 		FileType:        fileType,
 		UploadedAt:      uploadedAt,
 		ExpiresAt:       expiresAt,
 		TelegramUserID:  msg.From.ID,
-		IsProxied:       true, // Always proxy - instant response
+		TelegramURLUpdatedAt: uploadedAt,
 	}
 
+	// Unless a local Bot API server already has the file on its disk
+	if localPath != "" {
+		record.SourceKind = database.SourceBotAPI
+		record.SourceLocator = localPath
+	}
+
+	// Telegram's MIME type is often generic or wrong, check the actual bytes
+	record.FileType = b.sniffFileType(record)
+
+	// Password protect the links if the caption asks for it
+	if password := captionPassword(msg.Caption); password != "" {
+		hash, err := utils.HashPassword(password)
+		if err != nil {
+			log.Printf("Error hashing password for %s: %v", fileID, err)
+			b.sendError(msg.Chat.ID, "Failed to set password")
+			return
+		}
+		record.PasswordHash = hash
+	}
+
+	// Optional view/download caps from the caption
+	record.MaxDownloads = captionLimit(msg.Caption, "max downloads", "downloads")
+	record.MaxStreams = captionLimit(msg.Caption, "max streams", "max views", "streams", "views")
+
 	// Insert BEFORE sending links (must be in DB when user clicks link)
 	if err := b.db.InsertFile(record); err != nil {
 		log.Printf("Error inserting file record: %v", err)
@@ -342,12 +260,18 @@ func (b *Bot) handleMessage(msg *tgbotapi.Message) {
 	}
 
 	log.Printf("File %s (%d bytes) ready, ID: %s, expires: %v", fileName, fileSize, fileID, expiresAt)
+	metrics.FilesIngested.Inc(mediaType)
+
+	// Read duration, resolution and tracks from the container headers
+	b.probeMedia(record)
 
 	// Send reply with links
 	b.sendFileLinks(msg.Chat.ID, record)
 }
 
-func (b *Bot) downloadFile(fileID string) ([]byte, error) {
+// downloadFile fetches a file's contents from Telegram, refusing files larger
+// than maxSize bytes
+func (b *Bot) downloadFile(fileID string, maxSize int64) ([]byte, error) {
 	file, err := b.api.GetFile(tgbotapi.FileConfig{FileID: fileID})
 	if err != nil {
 		// Check if error is about file being too big
@@ -357,6 +281,23 @@ func (b *Bot) downloadFile(fileID string) ([]byte, error) {
 		return nil, fmt.Errorf("failed to get file info: %w", err)
 	}
 
+	// A local Bot API server has the file on its disk already
+	if path, ok := botapi.LocalFilePath(b.config, file.FilePath); ok {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file: %w", err)
+		}
+		defer f.Close()
+		data, err := io.ReadAll(io.LimitReader(f, maxSize+1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file: %w", err)
+		}
+		if int64(len(data)) > maxSize {
+			return nil, fmt.Errorf("file too large: the limit is %d bytes", maxSize)
+		}
+		return data, nil
+	}
+
 	fileURL := file.Link(b.api.Token)
 	
 	// Use http.Get with timeout for large files
@@ -372,17 +313,27 @@ func (b *Bot) downloadFile(fileID string) ([]byte, error) {
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("failed to download file: status %d", resp.StatusCode)
 	}
+	if resp.ContentLength > maxSize {
+		return nil, fmt.Errorf("file too large: the limit is %d bytes", maxSize)
+	}
+
+	// The reported length can't be trusted, stop reading past the limit
+	body := io.LimitReader(resp.Body, maxSize+1)
 
 	// For very large files, read in chunks to avoid memory issues
 	const maxMemorySize = 100 * 1024 * 1024 // 100MB
+	var data []byte
 	if resp.ContentLength > maxMemorySize {
 		// Stream to temp file instead of memory
-		return b.downloadLargeFile(resp.Body, resp.ContentLength)
+		data, err = b.downloadLargeFile(body, resp.ContentLength)
+	} else if data, err = io.ReadAll(body); err != nil {
+		err = fmt.Errorf("failed to read file data: %w", err)
 	}
-
-	data, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read file data: %w", err)
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("file too large: the limit is %d bytes", maxSize)
 	}
 
 	return data, nil
@@ -403,34 +354,69 @@ func (b *Bot) downloadLargeFile(reader io.Reader, contentLength int64) ([]byte,
 }
 
 func (b *Bot) sendFileLinks(chatID int64, record *database.FileRecord) {
-	streamURL := fmt.Sprintf("https://%s/stream/%s", b.domain, record.ID)
-	downloadURL := fmt.Sprintf("https://%s/file/%s", b.domain, record.ID)
+	query := b.signedQuery(record)
+	watchURL := fmt.Sprintf("https://%s/watch/%s%s", b.domain, record.ID, query)
+	streamURL := fmt.Sprintf("https://%s/stream/%s%s", b.domain, record.ID, query)
+	downloadURL := fmt.Sprintf("https://%s/file/%s%s", b.domain, record.ID, query)
+
+	notes := ""
+	if record.PasswordHash != "" {
+		notes = "\n🔒 Password protected"
+	}
+	if record.MaxDownloads > 0 || record.MaxStreams > 0 {
+		notes += "\nLimits: " + formatLimits(record)
+	}
 
 	expiresIn := time.Until(record.ExpiresAt)
 	expiresInDays := int(expiresIn.Hours() / 24)
 
 	message := fmt.Sprintf(`🎬 Stream Ready
 
-Play Online:
+Watch Online:
+%s
+
+Stream (VLC / external players):
 %s
 
 Download:
 %s
 
 Type: %s
-Size: %.2f GB
-Valid for %d days`, 
+Size: %.2f GB%s
+Valid for %d days
+File ID: %s%s`, 
+		watchURL,
 		streamURL,
 		downloadURL,
 		record.FileType,
 		float64(record.FileSize)/(1024*1024*1024),
+		mediaSummary(record.Media),
 		expiresInDays,
+		record.ID,
+		notes,
 	)
 
 	msg := tgbotapi.NewMessage(chatID, message)
 	b.api.Send(msg)
 }
 
+// signedQuery returns the expiry and signature to append to a file's links,
+// or "" when signed links are disabled
+func (b *Bot) signedQuery(record *database.FileRecord) string {
+	secret := b.config.Security.SigningSecret
+	if secret == "" {
+		return ""
+	}
+
+	expires := record.ExpiresAt
+	if ttl := b.config.Security.LinkTTLHours; ttl > 0 {
+		if limit := time.Now().Add(time.Duration(ttl) * time.Hour); limit.Before(expires) {
+			expires = limit
+		}
+	}
+	return utils.SignedQuery(secret, record.ID, expires)
+}
+
 func (b *Bot) sendError(chatID int64, errorMsg string) {
 	msg := tgbotapi.NewMessage(chatID, "❌ Error: "+errorMsg)
 	b.api.Send(msg)
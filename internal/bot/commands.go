@@ -0,0 +1,252 @@
+package bot
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"hafton-movie-bot/internal/database"
+	"hafton-movie-bot/internal/utils"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// handleCommand dispatches bot commands such as /password
+func (b *Bot) handleCommand(msg *tgbotapi.Message) {
+	switch msg.Command() {
+	case "password":
+		b.handlePasswordCommand(msg)
+	case "nopassword":
+		b.handleNoPasswordCommand(msg)
+	case "limit":
+		b.handleLimitCommand(msg)
+	case "playlist":
+		b.handlePlaylistCommand(msg)
+	}
+}
+
+// ownedFile looks up a file the sender is allowed to change
+func (b *Bot) ownedFile(msg *tgbotapi.Message, fileID string) (*database.FileRecord, bool) {
+	record, err := b.db.GetFileByID(fileID)
+	if err != nil || record.TelegramUserID != msg.From.ID {
+		b.sendError(msg.Chat.ID, fmt.Sprintf("File %s not found", fileID))
+		return nil, false
+	}
+	return record, true
+}
+
+// handlePasswordCommand handles "/password <file id> <password>"
+func (b *Bot) handlePasswordCommand(msg *tgbotapi.Message) {
+	args := strings.Fields(msg.CommandArguments())
+	if len(args) != 2 {
+		b.sendError(msg.Chat.ID, "Usage: /password <file id> <password>")
+		return
+	}
+
+	record, ok := b.ownedFile(msg, args[0])
+	if !ok {
+		return
+	}
+
+	hash, err := utils.HashPassword(args[1])
+	if err != nil {
+		log.Printf("Error hashing password for %s: %v", record.ID, err)
+		b.sendError(msg.Chat.ID, "Failed to set password")
+		return
+	}
+	if err := b.db.SetFilePassword(record.ID, hash); err != nil {
+		log.Printf("Error saving password for %s: %v", record.ID, err)
+		b.sendError(msg.Chat.ID, "Failed to set password")
+		return
+	}
+
+	b.api.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("🔒 %s is now password protected", record.FileName)))
+}
+
+// handleNoPasswordCommand handles "/nopassword <file id>"
+func (b *Bot) handleNoPasswordCommand(msg *tgbotapi.Message) {
+	args := strings.Fields(msg.CommandArguments())
+	if len(args) != 1 {
+		b.sendError(msg.Chat.ID, "Usage: /nopassword <file id>")
+		return
+	}
+
+	record, ok := b.ownedFile(msg, args[0])
+	if !ok {
+		return
+	}
+
+	if err := b.db.SetFilePassword(record.ID, ""); err != nil {
+		log.Printf("Error removing password for %s: %v", record.ID, err)
+		b.sendError(msg.Chat.ID, "Failed to remove password")
+		return
+	}
+
+	b.api.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("🔓 Password removed from %s", record.FileName)))
+}
+
+// handleLimitCommand handles "/limit <file id> <downloads|streams> <n>"
+func (b *Bot) handleLimitCommand(msg *tgbotapi.Message) {
+	args := strings.Fields(msg.CommandArguments())
+	usage := "Usage: /limit <file id> <downloads|streams> <count> (0 = unlimited)"
+	if len(args) != 3 {
+		b.sendError(msg.Chat.ID, usage)
+		return
+	}
+	count, err := strconv.Atoi(args[2])
+	if err != nil || count < 0 {
+		b.sendError(msg.Chat.ID, usage)
+		return
+	}
+
+	record, ok := b.ownedFile(msg, args[0])
+	if !ok {
+		return
+	}
+
+	switch args[1] {
+	case "downloads", "download":
+		record.MaxDownloads = count
+	case "streams", "stream", "views":
+		record.MaxStreams = count
+	default:
+		b.sendError(msg.Chat.ID, usage)
+		return
+	}
+
+	if err := b.db.SetFileLimits(record.ID, record.MaxDownloads, record.MaxStreams); err != nil {
+		log.Printf("Error saving limits for %s: %v", record.ID, err)
+		b.sendError(msg.Chat.ID, "Failed to set limit")
+		return
+	}
+
+	b.api.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("✅ Limits for %s: %s", record.FileName, formatLimits(record))))
+}
+
+// handlePlaylistCommand handles "/playlist [file id...]" and "/playlist revoke".
+// Without file IDs the playlist follows all of the user's active files and
+// the same link is returned every time.
+func (b *Bot) handlePlaylistCommand(msg *tgbotapi.Message) {
+	args := strings.Fields(msg.CommandArguments())
+
+	if len(args) == 1 && args[0] == "revoke" {
+		if err := b.db.DeleteUserPlaylists(msg.From.ID); err != nil {
+			log.Printf("Error revoking playlists of user %d: %v", msg.From.ID, err)
+			b.sendError(msg.Chat.ID, "Failed to revoke playlists")
+			return
+		}
+		b.api.Send(tgbotapi.NewMessage(msg.Chat.ID, "🗑 Your playlist links no longer work. Send /playlist for a new one."))
+		return
+	}
+
+	var playlist *database.Playlist
+	if len(args) == 0 {
+		if existing, err := b.db.GetAllFilesPlaylist(msg.From.ID); err == nil {
+			playlist = existing
+		}
+	} else {
+		args = uniqueFields(args)
+		for _, fileID := range args {
+			record, ok := b.ownedFile(msg, fileID)
+			if !ok {
+				return
+			}
+			if time.Now().After(record.ExpiresAt) {
+				b.sendError(msg.Chat.ID, fmt.Sprintf("File %s has expired", fileID))
+				return
+			}
+		}
+	}
+
+	if playlist == nil {
+		token, err := utils.GenerateToken()
+		if err != nil {
+			log.Printf("Error generating playlist token: %v", err)
+			b.sendError(msg.Chat.ID, "Failed to create playlist")
+			return
+		}
+		playlist = &database.Playlist{
+			Token:          token,
+			TelegramUserID: msg.From.ID,
+			FileIDs:        args,
+			CreatedAt:      time.Now(),
+		}
+		if err := b.db.SavePlaylist(playlist); err != nil {
+			log.Printf("Error saving playlist for user %d: %v", msg.From.ID, err)
+			b.sendError(msg.Chat.ID, "Failed to create playlist")
+			return
+		}
+	}
+
+	contents := "all your active files"
+	if len(playlist.FileIDs) > 0 {
+		contents = fmt.Sprintf("%d files", len(playlist.FileIDs))
+	}
+	b.api.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf(`📃 Playlist of %s:
+https://%s/playlist/%s.m3u8
+
+Open it in VLC or another player. Password protected files are left out.
+Anyone with the link can play these files; /playlist revoke disables it.`,
+		contents, b.domain, playlist.Token)))
+}
+
+// uniqueFields drops repeated values, keeping the first occurrence
+func uniqueFields(fields []string) []string {
+	var unique []string
+	seen := make(map[string]bool)
+	for _, field := range fields {
+		if !seen[field] {
+			seen[field] = true
+			unique = append(unique, field)
+		}
+	}
+	return unique
+}
+
+// formatLimits describes a file's download and stream caps
+func formatLimits(record *database.FileRecord) string {
+	describe := func(used, max int) string {
+		if max == 0 {
+			return "unlimited"
+		}
+		return fmt.Sprintf("%d/%d used", used, max)
+	}
+	return fmt.Sprintf("downloads %s, streams %s",
+		describe(record.DownloadCount, record.MaxDownloads),
+		describe(record.StreamCount, record.MaxStreams),
+	)
+}
+
+// captionValue returns the value of the first "key: value" caption line whose
+// key matches one of keys (case-insensitive)
+func captionValue(caption string, keys ...string) string {
+	for _, line := range strings.Split(caption, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		for _, want := range keys {
+			if key == want {
+				return strings.TrimSpace(value)
+			}
+		}
+	}
+	return ""
+}
+
+// captionPassword extracts a "password: ..." line from a file caption
+func captionPassword(caption string) string {
+	return captionValue(caption, "password", "pass")
+}
+
+// captionLimit extracts a numeric limit such as "max downloads: 3"
+func captionLimit(caption string, keys ...string) int {
+	n, err := strconv.Atoi(captionValue(caption, keys...))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
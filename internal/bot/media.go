@@ -0,0 +1,125 @@
+package bot
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"hafton-movie-bot/internal/database"
+	"hafton-movie-bot/internal/mediainfo"
+	"hafton-movie-bot/internal/sniff"
+)
+
+// probeTimeout bounds each ranged request made while probing a new upload
+const probeTimeout = 20 * time.Second
+
+// probeMedia reads the container headers of a new upload from Telegram and
+// stores them with the record
+func (b *Bot) probeMedia(record *database.FileRecord) {
+	if record.FileSize <= 0 || !mediainfo.Probeable(record.FileName, record.FileType) {
+		return
+	}
+
+	reader, closeReader, err := b.openUpload(record)
+	if err != nil {
+		return
+	}
+	defer closeReader()
+
+	info, err := mediainfo.Probe(reader, record.FileSize)
+	if err != nil {
+		log.Printf("Could not probe %s: %v", record.ID, err)
+		info = nil
+	}
+
+	if err := b.db.UpdateMediaInfo(record.ID, info); err != nil {
+		log.Printf("Error saving media info for %s: %v", record.ID, err)
+		return
+	}
+	if info == nil {
+		info = &mediainfo.Info{}
+	}
+	record.Media = info
+}
+
+// sniffFileType checks the first bytes of an upload against known file
+// signatures, since Telegram's MIME type is often generic or wrong
+func (b *Bot) sniffFileType(record *database.FileRecord) string {
+	fileType := record.FileType
+	if record.FileSize <= 0 {
+		return fileType
+	}
+
+	reader, closeReader, err := b.openUpload(record)
+	if err != nil {
+		return fileType
+	}
+	defer closeReader()
+
+	head := make([]byte, min(int64(sniff.HeaderSize), record.FileSize))
+	if _, err := reader.ReadAt(head, 0); err != nil {
+		log.Printf("Could not read file header for type detection: %v", err)
+		return fileType
+	}
+
+	resolved := sniff.Resolve(fileType, sniff.Detect(head))
+	if resolved != fileType {
+		log.Printf("Detected content type %s (declared %s)", resolved, fileType)
+	}
+	return resolved
+}
+
+// openUpload reads a new upload from a local Bot API server's disk or with
+// ranged requests to its Telegram link
+func (b *Bot) openUpload(record *database.FileRecord) (io.ReaderAt, func(), error) {
+	switch {
+	case record.SourceKind == database.SourceBotAPI:
+		file, err := os.Open(record.SourceLocator)
+		if err != nil {
+			return nil, nil, err
+		}
+		return file, func() { file.Close() }, nil
+	case record.SourceKind == database.SourceTelegram && record.SourceLocator != "":
+		reader := &mediainfo.HTTPReaderAt{
+			Client: &http.Client{Timeout: probeTimeout},
+			URL:    record.SourceLocator,
+		}
+		return reader, func() {}, nil
+	default:
+		return nil, nil, fmt.Errorf("no way to read %s from %s", record.ID, record.SourceKind)
+	}
+}
+
+// mediaSummary describes probed media details for the links message
+func mediaSummary(info *mediainfo.Info) string {
+	if !info.Known() {
+		return ""
+	}
+
+	var parts []string
+	if info.Duration > 0 {
+		parts = append(parts, mediainfo.FormatDuration(info.Duration))
+	}
+	if resolution := info.Resolution(); resolution != "" {
+		parts = append(parts, resolution)
+	}
+	if codecs := info.Codecs(); codecs != "" {
+		parts = append(parts, codecs)
+	}
+
+	summary := ""
+	if len(parts) > 0 {
+		summary += "\n" + strings.Join(parts, " · ")
+	}
+	if len(info.AudioLanguages) > 0 {
+		summary += fmt.Sprintf("\nAudio: %s", strings.Join(info.AudioLanguages, ", "))
+	}
+	if len(info.SubtitleTracks) > 0 {
+		summary += fmt.Sprintf("\nSubtitles: %s", strings.Join(info.SubtitleTracks, ", "))
+	}
+	return summary
+}
@@ -0,0 +1,189 @@
+package bot
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"hafton-movie-bot/internal/database"
+	"hafton-movie-bot/internal/subtitles"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// defaultSubtitleMatchWindow applies when subtitles.match_window_minutes is unset
+const defaultSubtitleMatchWindow = 30 * time.Minute
+
+// maxSubtitleSize caps subtitle uploads, which are read into memory whole.
+// Real subtitle files are a few hundred KB at most.
+const maxSubtitleSize = 5 * 1024 * 1024
+
+var (
+	// linkIDPattern finds the file ID in a links message sent by sendFileLinks
+	linkIDPattern = regexp.MustCompile(`/(?:watch|stream|file)/([A-Za-z0-9]+)`)
+
+	// languagePattern matches codes such as "en", "eng" or "pt-BR"
+	languagePattern = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})?$`)
+)
+
+// handleSubtitle attaches a subtitle document to the video it belongs to. It
+// returns false when no video was found, so the document is handled like any
+// other file.
+func (b *Bot) handleSubtitle(msg *tgbotapi.Message) bool {
+	doc := msg.Document
+	format := subtitles.DetectFormat(doc.FileName)
+
+	record := b.subtitleTarget(msg)
+	if record == nil {
+		return false
+	}
+
+	if int64(doc.FileSize) > maxSubtitleSize {
+		b.sendError(msg.Chat.ID, fmt.Sprintf("Subtitle %s is too large, the limit is %d MB", doc.FileName, maxSubtitleSize/(1024*1024)))
+		return true
+	}
+
+	data, err := b.downloadFile(doc.FileID, maxSubtitleSize)
+	if err != nil {
+		log.Printf("Error downloading subtitle %s: %v", doc.FileName, err)
+		b.sendError(msg.Chat.ID, fmt.Sprintf("Failed to download subtitle: %v", err))
+		return true
+	}
+
+	// Make sure it converts before accepting it
+	if _, err := subtitles.ToWebVTT(data, format); err != nil {
+		b.sendError(msg.Chat.ID, fmt.Sprintf("Could not read subtitle %s: %v", doc.FileName, err))
+		return true
+	}
+
+	language := subtitleLanguage(doc.FileName, msg.Caption)
+	sub := &database.Subtitle{
+		FileID:    record.ID,
+		Language:  language,
+		Format:    format,
+		FileName:  fmt.Sprintf("subtitle.%s.%s", language, format),
+		CreatedAt: time.Now(),
+	}
+
+	if err := b.storage.SaveFile(record.ID, sub.FileName, data); err != nil {
+		log.Printf("Error saving subtitle for %s: %v", record.ID, err)
+		b.sendError(msg.Chat.ID, "Failed to save subtitle")
+		return true
+	}
+	if err := b.db.SaveSubtitle(sub); err != nil {
+		log.Printf("Error saving subtitle record for %s: %v", record.ID, err)
+		b.sendError(msg.Chat.ID, "Failed to save subtitle")
+		return true
+	}
+
+	log.Printf("Subtitle %s (%s) attached to %s", doc.FileName, language, record.ID)
+
+	query := b.signedQuery(record)
+	message := fmt.Sprintf(`💬 Subtitle attached to %s
+
+Language: %s
+
+Watch Online:
+https://%s/watch/%s%s
+
+WebVTT:
+https://%s/subs/%s/%s.vtt%s`,
+		record.FileName,
+		language,
+		b.domain, record.ID, query,
+		b.domain, record.ID, language, query,
+	)
+	b.api.Send(tgbotapi.NewMessage(msg.Chat.ID, message))
+	return true
+}
+
+// subtitleTarget finds the sender's file a subtitle belongs to: the file of
+// the message it replies to, or else a recent upload with the same base name
+func (b *Bot) subtitleTarget(msg *tgbotapi.Message) *database.FileRecord {
+	usable := func(record *database.FileRecord, err error) bool {
+		return err == nil && record.TelegramUserID == msg.From.ID && time.Now().Before(record.ExpiresAt)
+	}
+
+	if reply := msg.ReplyToMessage; reply != nil {
+		// Reply to the bot's links message
+		if m := linkIDPattern.FindStringSubmatch(reply.Text); m != nil {
+			if record, err := b.db.GetFileByID(m[1]); usable(record, err) {
+				return record
+			}
+		}
+		// Reply to the uploaded video itself
+		if telegramFileID := messageFileID(reply); telegramFileID != "" {
+			if record, err := b.db.GetFileByTelegramFileID(msg.From.ID, telegramFileID); usable(record, err) {
+				return record
+			}
+		}
+	}
+
+	window := defaultSubtitleMatchWindow
+	if minutes := b.config.Subtitles.MatchWindowMinutes; minutes > 0 {
+		window = time.Duration(minutes) * time.Minute
+	}
+	records, err := b.db.GetRecentFilesByUser(msg.From.ID, time.Now().Add(-window))
+	if err != nil {
+		log.Printf("Error looking up recent files for subtitle match: %v", err)
+		return nil
+	}
+
+	want := subtitleBaseName(msg.Document.FileName)
+	for _, record := range records {
+		if subtitles.DetectFormat(record.FileName) != "" {
+			continue
+		}
+		if baseName(record.FileName) == want {
+			return record
+		}
+	}
+	return nil
+}
+
+// messageFileID returns the Telegram file ID of a media message
+func messageFileID(msg *tgbotapi.Message) string {
+	switch {
+	case msg.Video != nil:
+		return msg.Video.FileID
+	case msg.Document != nil:
+		return msg.Document.FileID
+	case msg.Audio != nil:
+		return msg.Audio.FileID
+	}
+	return ""
+}
+
+// baseName normalises a file name without extension for matching
+func baseName(fileName string) string {
+	name := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	name = strings.NewReplacer("_", ".", " ", ".").Replace(name)
+	return strings.ToLower(name)
+}
+
+// subtitleBaseName is baseName without a trailing language code, so
+// "Movie.2020.en.srt" matches "Movie.2020.mkv"
+func subtitleBaseName(fileName string) string {
+	name := baseName(fileName)
+	if i := strings.LastIndex(name, "."); i > 0 && languagePattern.MatchString(name[i+1:]) {
+		return name[:i]
+	}
+	return name
+}
+
+// subtitleLanguage takes the language from a "lang: xx" caption line or a
+// "Movie.xx.srt" file name, falling back to "und" (undetermined)
+func subtitleLanguage(fileName, caption string) string {
+	if lang := captionValue(caption, "lang", "language"); languagePattern.MatchString(lang) {
+		return strings.ToLower(lang)
+	}
+
+	name := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	if i := strings.LastIndexAny(name, "._ "); i > 0 && languagePattern.MatchString(name[i+1:]) {
+		return strings.ToLower(name[i+1:])
+	}
+	return "und"
+}
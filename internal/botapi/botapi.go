@@ -0,0 +1,178 @@
+// Package botapi creates Bot API clients and maps the files of a local Bot
+// API server, for both the bot and the HTTP server.
+package botapi
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"hafton-movie-bot/internal/config"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// urlFixerClient wraps an HTTP client to fix malformed URLs from the telegram-bot-api library
+type urlFixerClient struct {
+	baseClient *http.Client
+	baseURL    string
+	token      string
+}
+
+func (c *urlFixerClient) Do(req *http.Request) (*http.Response, error) {
+	// ALWAYS rewrite the URL to use our custom Bot API server
+	// The library constructs paths like: /bot{token}/{method}
+	// We need to rewrite to: {baseURL}/bot{token}/{method}
+
+	// Log the original request for debugging
+	log.Printf("Intercepting request: %s %s", req.Method, req.URL.String())
+
+	path := req.URL.Path
+
+	// Extract method from path
+	// Format is usually: /bot{token}/{method}
+	method := ""
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	if len(parts) >= 2 {
+		// Format: bot{token}/{method} - get the method (last part)
+		method = parts[len(parts)-1]
+	} else if len(parts) == 1 && parts[0] != "" && !strings.HasPrefix(parts[0], "bot") {
+		// Just the method
+		method = parts[0]
+	}
+
+	// Reconstruct proper URL
+	// Bot API server expects: https://server.com:8081/bot{token}/{method}
+	// But Render routes to port 10000, so we need to check if port is needed
+	if method != "" {
+		// Try with port 8081 first (Bot API server's internal port)
+		// But Render might route to 10000, so we'll try without port first
+		fixedURL := fmt.Sprintf("%s/bot%s/%s", c.baseURL, c.token, method)
+		parsedURL, err := url.Parse(fixedURL)
+		if err == nil {
+			req.URL = parsedURL
+			log.Printf("Rewrote URL: %s -> %s", path, fixedURL)
+		} else {
+			log.Printf("Failed to parse fixed URL %s: %v", fixedURL, err)
+			return nil, fmt.Errorf("failed to construct URL: %w", err)
+		}
+	} else {
+		// Fallback: use base URL + original path
+		fixedURL := c.baseURL + path
+		parsedURL, err := url.Parse(fixedURL)
+		if err == nil {
+			req.URL = parsedURL
+			log.Printf("Rewrote URL (fallback): %s -> %s", path, fixedURL)
+		} else {
+			log.Printf("Failed to parse fallback URL %s: %v", fixedURL, err)
+			return nil, fmt.Errorf("failed to construct fallback URL: %w", err)
+		}
+	}
+
+	// Log the response to debug what we're getting back
+	// We'll add this after the request
+
+	// Make the request
+	resp, err := c.baseClient.Do(req)
+	if err != nil {
+		return resp, err
+	}
+
+	// Log ALL responses for debugging (not just errors)
+	log.Printf("Bot API server returned status %d for %s", resp.StatusCode, req.URL.String())
+
+	// Always log response body preview to debug issues
+	if resp.Body != nil {
+		// Read first 500 chars to see what we're getting
+		bodyBytes := make([]byte, 500)
+		n, _ := resp.Body.Read(bodyBytes)
+		if n > 0 {
+			preview := string(bodyBytes[:n])
+			// Check if it's HTML (error page) or JSON
+			if strings.HasPrefix(strings.TrimSpace(preview), "<") {
+				log.Printf("⚠️ Got HTML response (error page): %s", preview)
+			} else {
+				log.Printf("✅ Got JSON response preview: %s", preview)
+			}
+		}
+		// Create a new reader that includes the bytes we already read
+		resp.Body = io.NopCloser(io.MultiReader(strings.NewReader(string(bodyBytes[:n])), resp.Body))
+	}
+
+	return resp, err
+}
+
+// New creates a Bot API client for the configured token, routed through the
+// custom Bot API server when one is set
+func New(cfg *config.Config) (*tgbotapi.BotAPI, error) {
+	var api *tgbotapi.BotAPI
+	var err error
+
+	// Use custom Bot API server if configured (for large file support)
+	if cfg.Telegram.BotAPIURL != "" {
+		// Clean up URL - remove trailing slashes and ensure proper format
+		apiEndpoint := strings.TrimSuffix(cfg.Telegram.BotAPIURL, "/")
+		// Ensure URL is valid
+		if !strings.HasPrefix(apiEndpoint, "http://") && !strings.HasPrefix(apiEndpoint, "https://") {
+			return nil, fmt.Errorf("Bot API URL must start with http:// or https://")
+		}
+
+		// If Bot API URL is set, try localhost first (Bot API runs in same container)
+		// This bypasses all networking issues!
+		originalEndpoint := apiEndpoint
+
+		if strings.Contains(apiEndpoint, ".onrender.com") || strings.Contains(apiEndpoint, "hafton-streamer-2") {
+			// External URL or service name detected - use localhost instead
+			// Bot API server runs in same container on port 8081
+			localhostURL := "http://localhost:8081"
+			log.Printf("🔄 Converting %s to localhost: %s (Bot API in same container)", originalEndpoint, localhostURL)
+			apiEndpoint = localhostURL
+		} else if strings.Contains(apiEndpoint, "localhost") || strings.Contains(apiEndpoint, "127.0.0.1") {
+			// Already localhost - perfect!
+			log.Printf("✅ Using localhost URL: %s (Bot API in same container)", apiEndpoint)
+		} else if !strings.Contains(apiEndpoint, ".onrender.com") && strings.Contains(apiEndpoint, ":") {
+			// Internal URL format
+			log.Printf("ℹ️ Using provided internal URL: %s", apiEndpoint)
+		}
+
+		log.Printf("Using custom Bot API server: %s", apiEndpoint)
+
+		// Create a custom HTTP client that intercepts requests and fixes URLs
+		// This works around a bug in the library's URL construction
+		baseClient := &http.Client{
+			Timeout: 30 * time.Second,
+		}
+
+		// Wrap the client to intercept and fix URLs
+		client := &urlFixerClient{
+			baseClient: baseClient,
+			baseURL:    apiEndpoint,
+			token:      cfg.Telegram.BotToken,
+		}
+
+		// DON'T use SetAPIEndpoint or NewBotAPIWithAPIEndpoint - they have bugs
+		// Instead, create bot with default API and override the client
+		// The client will intercept and rewrite all URLs
+		api, err = tgbotapi.NewBotAPI(cfg.Telegram.BotToken)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create bot API: %w", err)
+		}
+
+		// Set custom client with URL fixer - this will rewrite all URLs
+		api.Client = client
+
+		log.Printf("Using custom Bot API server: %s (URLs will be rewritten by client)", apiEndpoint)
+	} else {
+		// Use default Telegram Bot API
+		api, err = tgbotapi.NewBotAPI(cfg.Telegram.BotToken)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create bot API: %w", err)
+		}
+	}
+
+	return api, nil
+}
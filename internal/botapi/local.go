@@ -0,0 +1,38 @@
+package botapi
+
+import (
+	"path/filepath"
+	"strings"
+
+	"hafton-movie-bot/internal/config"
+)
+
+// defaultLocalAPIDir is the working directory of the official Bot API server
+// image, used when the config leaves it unset
+const defaultLocalAPIDir = "/var/lib/telegram-bot-api"
+
+// LocalFilePath maps a file_path returned by a Bot API server running with
+// --local to where the file can be read here. It reports false for ordinary
+// relative paths, which have to be downloaded over HTTP.
+func LocalFilePath(cfg *config.Config, filePath string) (string, bool) {
+	if !cfg.Telegram.LocalMode || !filepath.IsAbs(filePath) {
+		return "", false
+	}
+
+	filePath = filepath.Clean(filePath)
+	localDir := cfg.Telegram.LocalFilesDir
+	if localDir == "" {
+		return filePath, true
+	}
+
+	apiDir := cfg.Telegram.LocalAPIDir
+	if apiDir == "" {
+		apiDir = defaultLocalAPIDir
+	}
+	rel, err := filepath.Rel(apiDir, filePath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		// Outside the Bot API's directory, so not covered by the mapping
+		return filePath, true
+	}
+	return filepath.Join(localDir, rel), true
+}
@@ -1,10 +1,12 @@
 package cleanup
 
 import (
+	"context"
 	"log"
 	"time"
 
 	"hafton-movie-bot/internal/database"
+	"hafton-movie-bot/internal/metrics"
 	"hafton-movie-bot/internal/storage"
 )
 
@@ -22,36 +24,52 @@ func New(db *database.DB, storage *storage.Storage, interval time.Duration) *Cle
 	}
 }
 
-func (c *Cleanup) Start() {
+// Start runs cleanup now and then on every interval until ctx is cancelled.
+// A run in progress finishes the file it is deleting before returning.
+func (c *Cleanup) Start(ctx context.Context) {
 	// Run immediately on start
-	c.runCleanup()
+	c.runCleanup(ctx)
 
 	// Then run on interval
 	ticker := time.NewTicker(c.interval)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		c.runCleanup()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.runCleanup(ctx)
+		}
 	}
 }
 
-func (c *Cleanup) runCleanup() {
+func (c *Cleanup) runCleanup(ctx context.Context) {
 	log.Println("Running cleanup for expired files...")
 
 	expiredFiles, err := c.db.GetExpiredFiles()
 	if err != nil {
 		log.Printf("Error fetching expired files: %v", err)
+		metrics.CleanupFailures.Inc()
 		return
 	}
 
 	if len(expiredFiles) == 0 {
 		log.Println("No expired files to clean up")
+		metrics.CleanupRuns.Inc()
 		return
 	}
 
 	log.Printf("Found %d expired files to delete", len(expiredFiles))
 
+	deleted := 0
 	for _, record := range expiredFiles {
+		if ctx.Err() != nil {
+			log.Printf("Cleanup interrupted by shutdown, %d expired files left for next run", len(expiredFiles)-deleted)
+			return
+		}
+		deleted++
+
 		// Delete file from storage
 		if err := c.storage.DeleteFileDir(record.ID); err != nil {
 			log.Printf("Error deleting file directory for %s: %v", record.ID, err)
@@ -59,14 +77,30 @@ func (c *Cleanup) runCleanup() {
 			log.Printf("Deleted file directory: %s", record.ID)
 		}
 
+		// Delete cached chunks of proxied files
+		if cache := c.storage.Cache(); cache != nil {
+			if err := cache.DeleteFile(record.ID); err != nil {
+				log.Printf("Error deleting cached chunks for %s: %v", record.ID, err)
+			}
+		}
+
 		// Delete record from database
 		if err := c.db.DeleteFile(record.ID); err != nil {
 			log.Printf("Error deleting database record for %s: %v", record.ID, err)
 		} else {
 			log.Printf("Deleted database record: %s", record.ID)
+			metrics.CleanupDeletions.Inc()
 		}
 	}
 
+	// Playlists whose files have all been deleted can't be played any more
+	if n, err := c.db.DeleteEmptyPlaylists(); err != nil {
+		log.Printf("Error deleting empty playlists: %v", err)
+	} else if n > 0 {
+		log.Printf("Deleted %d empty playlists", n)
+	}
+
 	log.Printf("Cleanup completed. Deleted %d expired files", len(expiredFiles))
+	metrics.CleanupRuns.Inc()
 }
 
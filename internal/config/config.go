@@ -13,11 +13,24 @@ type Config struct {
 		BotAPIURL   string `yaml:"bot_api_url"`   // Custom Bot API server URL (optional)
 		APIID       string `yaml:"api_id"`         // For self-hosted Bot API server
 		APIHash     string `yaml:"api_hash"`       // For self-hosted Bot API server
+		FileLinkTTLMinutes int `yaml:"file_link_ttl_minutes"` // Re-resolve file links older than this
+		LocalMode     bool   `yaml:"local_mode"`      // Bot API server runs with --local, files are read from its disk
+		LocalAPIDir   string `yaml:"local_api_dir"`   // Bot API server's working directory as it reports it
+		LocalFilesDir string `yaml:"local_files_dir"` // Same directory as mounted here ("" = paths are used as reported)
 	} `yaml:"telegram"`
 	Server struct {
 		Port        int    `yaml:"port"`
 		Domain      string `yaml:"domain"`
 		StoragePath string `yaml:"storage_path"`
+		Faststart   bool   `yaml:"faststart"` // Serve MP4s with moov at the end as if it came first
+		TrustedProxies []string `yaml:"trusted_proxies"` // CIDRs allowed to set X-Forwarded-For (default: private ranges)
+		ShutdownTimeoutSeconds int `yaml:"shutdown_timeout_seconds"` // How long active streams may drain on shutdown
+		TLS struct {
+			CertFile     string `yaml:"cert_file"`     // Serve HTTPS directly when cert and key are set
+			KeyFile      string `yaml:"key_file"`
+			RedirectHTTP bool   `yaml:"redirect_http"` // Redirect plain HTTP to HTTPS
+			HTTPPort     int    `yaml:"http_port"`     // Port for the redirect listener
+		} `yaml:"tls"`
 	} `yaml:"server"`
 	Database struct {
 		Path string `yaml:"path"`
@@ -25,6 +38,56 @@ type Config struct {
 	Retention struct {
 		Days int `yaml:"days"`
 	} `yaml:"retention"`
+	Security struct {
+		SigningSecret string `yaml:"signing_secret"` // Enables HMAC-signed links when set
+		AllowUnsigned bool   `yaml:"allow_unsigned"` // Keep accepting bare /stream/{id} links
+		LinkTTLHours  int    `yaml:"link_ttl_hours"` // Signed link lifetime (0 = until the file expires)
+	} `yaml:"security"`
+	Connections struct {
+		PerFile int `yaml:"per_file"` // Simultaneous clients per file (0 = unlimited)
+		PerIP   int `yaml:"per_ip"`   // Simultaneous streams per client IP (0 = unlimited)
+		Total   int `yaml:"total"`    // Simultaneous streams overall (0 = unlimited)
+		PerClient int `yaml:"per_client"` // Simultaneous requests from one client for one file (0 = unlimited)
+	} `yaml:"connections"`
+	Bandwidth struct {
+		GlobalKBps           int `yaml:"global_kbps"`            // All responses together (0 = unlimited)
+		PerConnectionKBps    int `yaml:"per_connection_kbps"`    // Each response (0 = unlimited)
+		PerFileKBps          int `yaml:"per_file_kbps"`          // All responses for one file (0 = unlimited)
+		DownloadSharePercent int `yaml:"download_share_percent"` // Share of the global/per-connection caps downloads may use
+	} `yaml:"bandwidth"`
+	Metrics struct {
+		Enabled bool   `yaml:"enabled"`
+		Token   string `yaml:"token"`  // Bearer token required on /metrics
+		Listen  string `yaml:"listen"` // Serve /metrics on a separate address, e.g. 127.0.0.1:9100
+	} `yaml:"metrics"`
+	Cache struct {
+		Enabled     bool `yaml:"enabled"`       // Keep proxied files on local disk
+		MaxSizeMB   int  `yaml:"max_size_mb"`   // LRU budget for cached chunks
+		ChunkSizeKB int  `yaml:"chunk_size_kb"` // Size of each cached piece
+	} `yaml:"cache"`
+	Coalesce struct {
+		Enabled     bool `yaml:"enabled"`       // Share Telegram fetches between viewers of the same file
+		BufferMB    int  `yaml:"buffer_mb"`     // Memory for recently fetched chunks
+		ChunkSizeKB int  `yaml:"chunk_size_kb"` // Size of each shared piece (the cache's chunk size is used when it is enabled)
+	} `yaml:"coalesce"`
+	Parallel struct {
+		Enabled     bool `yaml:"enabled"`       // Fetch proxied files over several ranged connections at once
+		Connections int  `yaml:"connections"`   // Requests in flight per response
+		ChunkSizeKB int  `yaml:"chunk_size_kb"` // Size of each ranged request
+	} `yaml:"parallel"`
+	Upstream struct {
+		Retries        int `yaml:"retries"`          // Reconnects after Telegram drops a transfer (0 = default of 3, -1 = never)
+		RetryBackoffMS int `yaml:"retry_backoff_ms"` // Wait before the first reconnect, doubled for each further one
+	} `yaml:"upstream"`
+	Subtitles struct {
+		MatchWindowMinutes int `yaml:"match_window_minutes"` // Attach subtitles to uploads with the same name this recent
+	} `yaml:"subtitles"`
+	AccessLog struct {
+		Enabled    bool   `yaml:"enabled"`
+		Path       string `yaml:"path"`        // File to write JSON lines to ("" or "-" = stdout)
+		MaxSizeMB  int    `yaml:"max_size_mb"` // Rotate once the file grows past this (0 = never)
+		MaxBackups int    `yaml:"max_backups"` // Rotated files to keep
+	} `yaml:"access_log"`
 }
 
 func Load(configPath string) (*Config, error) {
@@ -48,13 +111,58 @@ func Load(configPath string) (*Config, error) {
 	if config.Server.StoragePath == "" {
 		config.Server.StoragePath = "./storage"
 	}
+	if config.Server.ShutdownTimeoutSeconds == 0 {
+		config.Server.ShutdownTimeoutSeconds = 30
+	}
+	if config.Server.TLS.HTTPPort == 0 {
+		config.Server.TLS.HTTPPort = 80
+	}
 	if config.Database.Path == "" {
 		config.Database.Path = "./data/bot.db"
 	}
+	if config.Telegram.FileLinkTTLMinutes == 0 {
+		config.Telegram.FileLinkTTLMinutes = 50
+	}
 	if config.Retention.Days == 0 {
 		config.Retention.Days = 5
 	}
 
+	if config.Bandwidth.DownloadSharePercent == 0 {
+		config.Bandwidth.DownloadSharePercent = 50
+	}
+	if config.Cache.MaxSizeMB == 0 {
+		config.Cache.MaxSizeMB = 1024
+	}
+	if config.Cache.ChunkSizeKB == 0 {
+		config.Cache.ChunkSizeKB = 1024
+	}
+	if config.Coalesce.BufferMB == 0 {
+		config.Coalesce.BufferMB = 64
+	}
+	if config.Coalesce.ChunkSizeKB == 0 {
+		config.Coalesce.ChunkSizeKB = 1024
+	}
+	if config.Parallel.Connections == 0 {
+		config.Parallel.Connections = 4
+	}
+	if config.Parallel.ChunkSizeKB == 0 {
+		config.Parallel.ChunkSizeKB = 1024
+	}
+	if config.AccessLog.MaxBackups == 0 {
+		config.AccessLog.MaxBackups = 5
+	}
+
 	return &config, nil
 }
 
+// LoadTelegramEnv fills the Telegram settings from the environment, for
+// deployments that run without a config file
+func (c *Config) LoadTelegramEnv() {
+	c.Telegram.BotToken = os.Getenv("TELEGRAM_BOT_TOKEN")
+	c.Telegram.BotAPIURL = os.Getenv("TELEGRAM_BOT_API_URL")          // Optional: custom Bot API server
+	c.Telegram.APIID = os.Getenv("TELEGRAM_API_ID")                   // For self-hosted Bot API
+	c.Telegram.APIHash = os.Getenv("TELEGRAM_API_HASH")               // For self-hosted Bot API
+	c.Telegram.LocalMode = os.Getenv("TELEGRAM_LOCAL_MODE") == "true" // Bot API server runs with --local
+	c.Telegram.LocalAPIDir = os.Getenv("TELEGRAM_LOCAL_API_DIR")
+	c.Telegram.LocalFilesDir = os.Getenv("TELEGRAM_LOCAL_FILES_DIR")
+}
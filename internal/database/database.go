@@ -2,10 +2,14 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log"
 	"time"
 
+	"hafton-movie-bot/internal/mediainfo"
+	"hafton-movie-bot/internal/metrics"
+
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -16,17 +20,50 @@ type DB struct {
 type FileRecord struct {
 	ID             string
 	TelegramFileID string
-	TelegramFileURL string // For large files, we store Telegram's direct URL
-	FilePath       string
 	FileName       string
 	FileSize       int64
 	FileType       string
 	UploadedAt     time.Time
 	ExpiresAt      time.Time
 	TelegramUserID int64
-	IsProxied      bool // True if file is proxied from Telegram, false if downloaded
+	SourceKind     string // Where the bytes come from, one of the Source* kinds
+	SourceLocator  string // Where to find the file within its source
+	TelegramURLUpdatedAt time.Time // When SourceLocator was last resolved via getFile
+	PasswordHash   string // PBKDF2 hash of the link password, empty if not protected
+	MaxDownloads   int    // Download sessions allowed (0 = unlimited)
+	MaxStreams     int    // Stream sessions allowed (0 = unlimited)
+	DownloadCount  int
+	StreamCount    int
+	Media          *mediainfo.Info // Container metadata, nil until the file has been probed
+}
+
+// Source kinds, each with its own meaning of FileRecord.SourceLocator
+const (
+	SourceStorage  = "storage"  // Our storage directory, the locator is the file's name in it
+	SourceTelegram = "telegram" // Proxied from Telegram, the locator is the download link
+	SourceBotAPI   = "bot_api"  // On a local Bot API server's disk, the locator is the path
+)
+
+// Subtitle is a subtitle track attached to a file
+type Subtitle struct {
+	FileID    string
+	Language  string // Language code from the file name or caption, "und" if unknown
+	Format    string // srt, ass or vtt
+	FileName  string // Name of the stored subtitle in the file's storage directory
+	CreatedAt time.Time
+}
+
+// Playlist is a token-addressed M3U playlist of a user's files
+type Playlist struct {
+	Token          string
+	TelegramUserID int64
+	FileIDs        []string // Files in playlist order, empty for all of the user's active files
+	CreatedAt      time.Time
 }
 
+// timestampFormat is how DATETIME columns are written
+const timestampFormat = "2006-01-02 15:04:05"
+
 func New(dbPath string) (*DB, error) {
 	conn, err := sql.Open("sqlite3", dbPath+"?_journal_mode=WAL&_foreign_keys=1")
 	if err != nil {
@@ -46,19 +83,61 @@ func (db *DB) initSchema() error {
 	CREATE TABLE IF NOT EXISTS files (
 		id TEXT PRIMARY KEY,
 		telegram_file_id TEXT NOT NULL,
-		telegram_file_url TEXT,
-		file_path TEXT,
+		telegram_file_url TEXT, -- Legacy, see source_locator
+		file_path TEXT, -- Legacy, see source_locator
 		file_name TEXT NOT NULL,
 		file_size INTEGER NOT NULL,
 		file_type TEXT NOT NULL,
 		uploaded_at DATETIME NOT NULL,
 		expires_at DATETIME NOT NULL,
 		telegram_user_id INTEGER NOT NULL,
-		is_proxied INTEGER DEFAULT 0
+		is_proxied INTEGER DEFAULT 0, -- Legacy, see source_kind
+		source_kind TEXT,
+		source_locator TEXT,
+		telegram_url_updated_at DATETIME,
+		password_hash TEXT,
+		max_downloads INTEGER DEFAULT 0,
+		max_streams INTEGER DEFAULT 0,
+		download_count INTEGER DEFAULT 0,
+		stream_count INTEGER DEFAULT 0,
+		media_container TEXT,
+		media_duration REAL,
+		media_width INTEGER,
+		media_height INTEGER,
+		video_codec TEXT,
+		audio_codec TEXT,
+		audio_languages TEXT,
+		subtitle_tracks TEXT,
+		media_probed_at DATETIME
 	);
 
 	CREATE INDEX IF NOT EXISTS idx_expires_at ON files(expires_at);
 	CREATE INDEX IF NOT EXISTS idx_telegram_file_id ON files(telegram_file_id);
+
+	CREATE TABLE IF NOT EXISTS subtitles (
+		file_id TEXT NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+		language TEXT NOT NULL,
+		format TEXT NOT NULL,
+		file_name TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		PRIMARY KEY (file_id, language)
+	);
+
+	CREATE TABLE IF NOT EXISTS playlists (
+		token TEXT PRIMARY KEY,
+		telegram_user_id INTEGER NOT NULL,
+		all_files INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_playlists_user ON playlists(telegram_user_id);
+
+	CREATE TABLE IF NOT EXISTS playlist_files (
+		token TEXT NOT NULL REFERENCES playlists(token) ON DELETE CASCADE,
+		file_id TEXT NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+		position INTEGER NOT NULL,
+		PRIMARY KEY (token, file_id)
+	);
 	`
 
 	_, err := db.conn.Exec(query)
@@ -70,12 +149,51 @@ func (db *DB) initSchema() error {
 	migrationQueries := []string{
 		"ALTER TABLE files ADD COLUMN telegram_file_url TEXT",
 		"ALTER TABLE files ADD COLUMN is_proxied INTEGER DEFAULT 0",
+		"ALTER TABLE files ADD COLUMN telegram_url_updated_at DATETIME",
+		"ALTER TABLE files ADD COLUMN password_hash TEXT",
+		"ALTER TABLE files ADD COLUMN max_downloads INTEGER DEFAULT 0",
+		"ALTER TABLE files ADD COLUMN max_streams INTEGER DEFAULT 0",
+		"ALTER TABLE files ADD COLUMN download_count INTEGER DEFAULT 0",
+		"ALTER TABLE files ADD COLUMN stream_count INTEGER DEFAULT 0",
+		"ALTER TABLE files ADD COLUMN media_container TEXT",
+		"ALTER TABLE files ADD COLUMN media_duration REAL",
+		"ALTER TABLE files ADD COLUMN media_width INTEGER",
+		"ALTER TABLE files ADD COLUMN media_height INTEGER",
+		"ALTER TABLE files ADD COLUMN video_codec TEXT",
+		"ALTER TABLE files ADD COLUMN audio_codec TEXT",
+		"ALTER TABLE files ADD COLUMN audio_languages TEXT",
+		"ALTER TABLE files ADD COLUMN subtitle_tracks TEXT",
+		"ALTER TABLE files ADD COLUMN media_probed_at DATETIME",
+		"ALTER TABLE files ADD COLUMN source_kind TEXT",
+		"ALTER TABLE files ADD COLUMN source_locator TEXT",
 	}
 	
 	for _, migrationQuery := range migrationQueries {
 		db.conn.Exec(migrationQuery) // Ignore errors if column already exists
 	}
 	
+	// Give rows from before file sources existed the source their old
+	// columns describe. Links of the file_id: large file workaround were
+	// never usable, so they are left empty.
+	_, err = db.conn.Exec(`
+	UPDATE files SET
+		source_kind = CASE
+			WHEN is_proxied = 1 THEN 'telegram'
+			WHEN COALESCE(file_path, '') != '' THEN 'bot_api'
+			ELSE 'storage'
+		END,
+		source_locator = CASE
+			WHEN is_proxied = 1 AND telegram_file_url LIKE 'file_id:%' THEN ''
+			WHEN is_proxied = 1 THEN COALESCE(telegram_file_url, '')
+			WHEN COALESCE(file_path, '') != '' THEN file_path
+			ELSE file_name
+		END
+	WHERE COALESCE(source_kind, '') = ''
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to migrate file sources: %w", err)
+	}
+
 	return nil
 }
 
@@ -84,20 +202,23 @@ func (db *DB) Close() error {
 }
 
 func (db *DB) InsertFile(record *FileRecord) error {
+	defer observeQuery("insert_file")()
+
 	query := `
 	INSERT INTO files (
-		id, telegram_file_id, telegram_file_url, file_path, file_name, file_size, 
-		file_type, uploaded_at, expires_at, telegram_user_id, is_proxied
-	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		id, telegram_file_id, source_kind, source_locator, file_name, file_size, 
+		file_type, uploaded_at, expires_at, telegram_user_id,
+		telegram_url_updated_at, password_hash, max_downloads, max_streams
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
 
-	isProxied := 0
-	if record.IsProxied {
-		isProxied = 1
-	}
+	uploadedAtStr := record.UploadedAt.Format(timestampFormat)
+	expiresAtStr := record.ExpiresAt.Format(timestampFormat)
 
-	uploadedAtStr := record.UploadedAt.Format("2006-01-02 15:04:05")
-	expiresAtStr := record.ExpiresAt.Format("2006-01-02 15:04:05")
+	urlUpdatedAt := record.TelegramURLUpdatedAt
+	if urlUpdatedAt.IsZero() {
+		urlUpdatedAt = record.UploadedAt
+	}
 	
 	log.Printf("Inserting file: ID=%s, uploaded_at=%s, expires_at=%s", record.ID, uploadedAtStr, expiresAtStr)
 	
@@ -105,15 +226,18 @@ func (db *DB) InsertFile(record *FileRecord) error {
 		query,
 		record.ID,
 		record.TelegramFileID,
-		record.TelegramFileURL,
-		record.FilePath,
+		record.SourceKind,
+		record.SourceLocator,
 		record.FileName,
 		record.FileSize,
 		record.FileType,
 		uploadedAtStr,
 		expiresAtStr,
 		record.TelegramUserID,
-		isProxied,
+		urlUpdatedAt.Format(timestampFormat),
+		record.PasswordHash,
+		record.MaxDownloads,
+		record.MaxStreams,
 	)
 
 	if err != nil {
@@ -123,31 +247,52 @@ func (db *DB) InsertFile(record *FileRecord) error {
 	return nil
 }
 
-func (db *DB) GetFileByID(id string) (*FileRecord, error) {
-	query := `
-	SELECT id, telegram_file_id, telegram_file_url, file_path, file_name, file_size, 
-	       file_type, uploaded_at, expires_at, telegram_user_id, is_proxied
-	FROM files
-	WHERE id = ?
-	`
+// fileColumns is the column list shared by every query that returns FileRecords
+const fileColumns = `id, telegram_file_id, source_kind, source_locator, file_name, file_size,
+	       file_type, uploaded_at, expires_at, telegram_user_id,
+	       telegram_url_updated_at, password_hash,
+	       max_downloads, max_streams, download_count, stream_count,
+	       media_container, media_duration, media_width, media_height,
+	       video_codec, audio_codec, audio_languages, subtitle_tracks, media_probed_at`
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
 
-	row := db.conn.QueryRow(query, id)
+func scanFileRecord(row rowScanner) (*FileRecord, error) {
 	record := &FileRecord{}
 
-	var uploadedAt, expiresAt sql.NullString
-	var isProxiedInt int
+	var uploadedAt, expiresAt, urlUpdatedAt, passwordHash sql.NullString
+	var container, videoCodec, audioCodec, audioLanguages, subtitleTracks, probedAt sql.NullString
+	var duration sql.NullFloat64
+	var width, height sql.NullInt64
 	err := row.Scan(
 		&record.ID,
 		&record.TelegramFileID,
-		&record.TelegramFileURL,
-		&record.FilePath,
+		&record.SourceKind,
+		&record.SourceLocator,
 		&record.FileName,
 		&record.FileSize,
 		&record.FileType,
 		&uploadedAt,
 		&expiresAt,
 		&record.TelegramUserID,
-		&isProxiedInt,
+		&urlUpdatedAt,
+		&passwordHash,
+		&record.MaxDownloads,
+		&record.MaxStreams,
+		&record.DownloadCount,
+		&record.StreamCount,
+		&container,
+		&duration,
+		&width,
+		&height,
+		&videoCodec,
+		&audioCodec,
+		&audioLanguages,
+		&subtitleTracks,
+		&probedAt,
 	)
 
 	if err != nil {
@@ -156,7 +301,7 @@ func (db *DB) GetFileByID(id string) (*FileRecord, error) {
 
 	// Parse uploaded_at
 	if uploadedAt.Valid && uploadedAt.String != "" {
-		if t, err := time.Parse("2006-01-02 15:04:05", uploadedAt.String); err == nil {
+		if t, err := parseTimestamp(uploadedAt.String); err == nil {
 			record.UploadedAt = t
 		} else {
 			return nil, fmt.Errorf("invalid uploaded_at format: %v", err)
@@ -167,7 +312,7 @@ func (db *DB) GetFileByID(id string) (*FileRecord, error) {
 
 	// Parse expires_at
 	if expiresAt.Valid && expiresAt.String != "" {
-		if t, err := time.Parse("2006-01-02 15:04:05", expiresAt.String); err == nil {
+		if t, err := parseTimestamp(expiresAt.String); err == nil {
 			record.ExpiresAt = t
 		} else {
 			return nil, fmt.Errorf("invalid expires_at format: %v", err)
@@ -177,15 +322,124 @@ func (db *DB) GetFileByID(id string) (*FileRecord, error) {
 		record.ExpiresAt = time.Now().AddDate(0, 0, 5)
 	}
 
-	record.IsProxied = isProxiedInt == 1
+	// Rows from before link refreshing existed got their URL at upload time
+	record.TelegramURLUpdatedAt = record.UploadedAt
+	if urlUpdatedAt.Valid && urlUpdatedAt.String != "" {
+		if t, err := parseTimestamp(urlUpdatedAt.String); err == nil {
+			record.TelegramURLUpdatedAt = t
+		}
+	}
+
+	record.PasswordHash = passwordHash.String
+
+	if probedAt.Valid {
+		record.Media = &mediainfo.Info{
+			Container:      container.String,
+			Duration:       duration.Float64,
+			Width:          int(width.Int64),
+			Height:         int(height.Int64),
+			VideoCodec:     videoCodec.String,
+			AudioCodec:     audioCodec.String,
+			AudioLanguages: decodeList(audioLanguages.String),
+			SubtitleTracks: decodeList(subtitleTracks.String),
+		}
+	}
 
 	return record, nil
 }
 
+func (db *DB) GetFileByID(id string) (*FileRecord, error) {
+	defer observeQuery("get_file")()
+
+	query := `
+	SELECT ` + fileColumns + `
+	FROM files
+	WHERE id = ?
+	`
+
+	return scanFileRecord(db.conn.QueryRow(query, id))
+}
+
+// GetFileByTelegramFileID returns the newest record a user created for a
+// Telegram file
+func (db *DB) GetFileByTelegramFileID(userID int64, telegramFileID string) (*FileRecord, error) {
+	defer observeQuery("get_file_by_telegram_id")()
+
+	query := `
+	SELECT ` + fileColumns + `
+	FROM files
+	WHERE telegram_user_id = ? AND telegram_file_id = ?
+	ORDER BY uploaded_at DESC
+	LIMIT 1
+	`
+
+	return scanFileRecord(db.conn.QueryRow(query, userID, telegramFileID))
+}
+
+// GetRecentFilesByUser returns a user's files uploaded since the given time,
+// newest first
+func (db *DB) GetRecentFilesByUser(userID int64, since time.Time) ([]*FileRecord, error) {
+	defer observeQuery("get_recent_files")()
+
+	query := `
+	SELECT ` + fileColumns + `
+	FROM files
+	WHERE telegram_user_id = ? AND uploaded_at >= ?
+	ORDER BY uploaded_at DESC
+	`
+
+	rows, err := db.conn.Query(query, userID, since.Format(timestampFormat))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []*FileRecord
+	for rows.Next() {
+		record, err := scanFileRecord(rows)
+		if err != nil {
+			continue
+		}
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+// GetActiveFilesByUser returns a user's unexpired files, oldest first
+func (db *DB) GetActiveFilesByUser(userID int64) ([]*FileRecord, error) {
+	defer observeQuery("get_active_files")()
+
+	query := `
+	SELECT ` + fileColumns + `
+	FROM files
+	WHERE telegram_user_id = ? AND expires_at >= datetime('now')
+	ORDER BY uploaded_at, file_name
+	`
+
+	rows, err := db.conn.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []*FileRecord
+	for rows.Next() {
+		record, err := scanFileRecord(rows)
+		if err != nil {
+			continue
+		}
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
 func (db *DB) GetExpiredFiles() ([]*FileRecord, error) {
+	defer observeQuery("get_expired_files")()
+
 	query := `
-	SELECT id, telegram_file_id, telegram_file_url, file_path, file_name, file_size, 
-	       file_type, uploaded_at, expires_at, telegram_user_id, is_proxied
+	SELECT ` + fileColumns + `
 	FROM files
 	WHERE expires_at < datetime('now')
 	`
@@ -198,49 +452,343 @@ func (db *DB) GetExpiredFiles() ([]*FileRecord, error) {
 
 	var records []*FileRecord
 	for rows.Next() {
-		record := &FileRecord{}
-		var uploadedAt, expiresAt sql.NullString
-		var isProxiedInt int
-
-		err := rows.Scan(
-			&record.ID,
-			&record.TelegramFileID,
-			&record.TelegramFileURL,
-			&record.FilePath,
-			&record.FileName,
-			&record.FileSize,
-			&record.FileType,
-			&uploadedAt,
-			&expiresAt,
-			&record.TelegramUserID,
-			&isProxiedInt,
-		)
+		record, err := scanFileRecord(rows)
+		if err != nil {
+			continue
+		}
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+// UpdateSourceLocator stores a file's freshly resolved locator, e.g. a new
+// Telegram download link
+func (db *DB) UpdateSourceLocator(id, locator string, updatedAt time.Time) error {
+	defer observeQuery("update_source_locator")()
+
+	query := `UPDATE files SET source_locator = ?, telegram_url_updated_at = ? WHERE id = ?`
+	_, err := db.conn.Exec(query, locator, updatedAt.Format(timestampFormat), id)
+	return err
+}
+
+// UpdateMediaInfo stores probed container metadata. A nil info records that
+// the file couldn't be probed, so it isn't tried again.
+func (db *DB) UpdateMediaInfo(id string, info *mediainfo.Info) error {
+	defer observeQuery("update_media_info")()
+
+	if info == nil {
+		info = &mediainfo.Info{}
+	}
+
+	query := `
+	UPDATE files SET
+		media_container = ?, media_duration = ?, media_width = ?, media_height = ?,
+		video_codec = ?, audio_codec = ?, audio_languages = ?, subtitle_tracks = ?,
+		media_probed_at = ?
+	WHERE id = ?
+	`
+	_, err := db.conn.Exec(query,
+		info.Container,
+		info.Duration,
+		info.Width,
+		info.Height,
+		info.VideoCodec,
+		info.AudioCodec,
+		encodeList(info.AudioLanguages),
+		encodeList(info.SubtitleTracks),
+		time.Now().Format(timestampFormat),
+		id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update media info: %w", err)
+	}
+	return nil
+}
+
+// encodeList stores a string list as a JSON array
+func encodeList(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(values)
+	return string(data)
+}
+
+func decodeList(value string) []string {
+	var values []string
+	if value != "" {
+		json.Unmarshal([]byte(value), &values)
+	}
+	return values
+}
+
+// UpdateFileType corrects the stored MIME type of a file
+func (db *DB) UpdateFileType(id, fileType string) error {
+	defer observeQuery("update_file_type")()
+
+	query := `UPDATE files SET file_type = ? WHERE id = ?`
+	_, err := db.conn.Exec(query, fileType, id)
+	return err
+}
+
+// SetFilePassword stores the password hash for a file ("" removes protection)
+func (db *DB) SetFilePassword(id, passwordHash string) error {
+	defer observeQuery("set_password")()
+
+	query := `UPDATE files SET password_hash = ? WHERE id = ?`
+	_, err := db.conn.Exec(query, passwordHash, id)
+	return err
+}
+
+// SetFileLimits sets the download and stream session caps (0 = unlimited)
+func (db *DB) SetFileLimits(id string, maxDownloads, maxStreams int) error {
+	defer observeQuery("set_limits")()
+
+	query := `UPDATE files SET max_downloads = ?, max_streams = ? WHERE id = ?`
+	_, err := db.conn.Exec(query, maxDownloads, maxStreams, id)
+	return err
+}
+
+// ConsumeDownload counts a new download session. It returns false without
+// counting when the file's download cap has been reached.
+func (db *DB) ConsumeDownload(id string) (bool, error) {
+	return db.consume(id, "download_count", "max_downloads")
+}
+
+// ConsumeStream counts a new stream session. It returns false without
+// counting when the file's stream cap has been reached.
+func (db *DB) ConsumeStream(id string) (bool, error) {
+	return db.consume(id, "stream_count", "max_streams")
+}
+
+// consume atomically increments a counter unless it reached its cap
+func (db *DB) consume(id, counterColumn, maxColumn string) (bool, error) {
+	defer observeQuery("consume_" + counterColumn)()
+
+	query := fmt.Sprintf(
+		`UPDATE files SET %[1]s = %[1]s + 1 WHERE id = ? AND (%[2]s = 0 OR %[1]s < %[2]s)`,
+		counterColumn, maxColumn,
+	)
+	result, err := db.conn.Exec(query, id)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+// observeQuery records how long a query took; use as defer observeQuery(name)()
+func observeQuery(name string) func() {
+	start := time.Now()
+	return func() {
+		metrics.DBQueryDuration.Observe(time.Since(start).Seconds(), name)
+	}
+}
+
+// parseTimestamp parses a DATETIME column. The sqlite3 driver hands DATETIME
+// values back as RFC 3339 strings when scanned into a string, while older rows
+// may still contain the raw "2006-01-02 15:04:05" format we insert.
+func parseTimestamp(value string) (time.Time, error) {
+	if t, err := time.Parse(timestampFormat, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+// SaveSubtitle attaches a subtitle to a file, replacing one in the same language
+func (db *DB) SaveSubtitle(sub *Subtitle) error {
+	defer observeQuery("save_subtitle")()
+
+	query := `
+	INSERT OR REPLACE INTO subtitles (file_id, language, format, file_name, created_at)
+	VALUES (?, ?, ?, ?, ?)
+	`
+	_, err := db.conn.Exec(query, sub.FileID, sub.Language, sub.Format, sub.FileName, sub.CreatedAt.Format(timestampFormat))
+	if err != nil {
+		return fmt.Errorf("failed to save subtitle: %w", err)
+	}
+	return nil
+}
+
+// GetSubtitles returns the subtitles attached to a file, oldest first
+func (db *DB) GetSubtitles(fileID string) ([]*Subtitle, error) {
+	defer observeQuery("get_subtitles")()
+
+	query := `
+	SELECT file_id, language, format, file_name, created_at
+	FROM subtitles
+	WHERE file_id = ?
+	ORDER BY created_at, language
+	`
+
+	rows, err := db.conn.Query(query, fileID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subs []*Subtitle
+	for rows.Next() {
+		sub, err := scanSubtitle(rows)
 		if err != nil {
 			continue
 		}
+		subs = append(subs, sub)
+	}
+
+	return subs, rows.Err()
+}
 
-		// Parse uploaded_at
-		if uploadedAt.Valid && uploadedAt.String != "" {
-			if t, err := time.Parse("2006-01-02 15:04:05", uploadedAt.String); err == nil {
-				record.UploadedAt = t
-			}
+// GetSubtitle returns a file's subtitle in one language
+func (db *DB) GetSubtitle(fileID, language string) (*Subtitle, error) {
+	defer observeQuery("get_subtitle")()
+
+	query := `
+	SELECT file_id, language, format, file_name, created_at
+	FROM subtitles
+	WHERE file_id = ? AND language = ?
+	`
+
+	return scanSubtitle(db.conn.QueryRow(query, fileID, language))
+}
+
+func scanSubtitle(row rowScanner) (*Subtitle, error) {
+	sub := &Subtitle{}
+	var createdAt string
+	if err := row.Scan(&sub.FileID, &sub.Language, &sub.Format, &sub.FileName, &createdAt); err != nil {
+		return nil, err
+	}
+	if t, err := parseTimestamp(createdAt); err == nil {
+		sub.CreatedAt = t
+	}
+	return sub, nil
+}
+
+// SavePlaylist stores a playlist and its files
+func (db *DB) SavePlaylist(playlist *Playlist) error {
+	defer observeQuery("save_playlist")()
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to save playlist: %w", err)
+	}
+	defer tx.Rollback()
+
+	allFiles := 0
+	if len(playlist.FileIDs) == 0 {
+		allFiles = 1
+	}
+	_, err = tx.Exec(
+		`INSERT INTO playlists (token, telegram_user_id, all_files, created_at) VALUES (?, ?, ?, ?)`,
+		playlist.Token, playlist.TelegramUserID, allFiles, playlist.CreatedAt.Format(timestampFormat),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save playlist: %w", err)
+	}
+
+	for i, fileID := range playlist.FileIDs {
+		_, err := tx.Exec(`INSERT INTO playlist_files (token, file_id, position) VALUES (?, ?, ?)`, playlist.Token, fileID, i)
+		if err != nil {
+			return fmt.Errorf("failed to save playlist: %w", err)
 		}
+	}
+
+	return tx.Commit()
+}
+
+// GetPlaylist returns the playlist with the given token
+func (db *DB) GetPlaylist(token string) (*Playlist, error) {
+	defer observeQuery("get_playlist")()
+
+	playlist := &Playlist{}
+	var allFiles int
+	var createdAt string
+	err := db.conn.QueryRow(
+		`SELECT token, telegram_user_id, all_files, created_at FROM playlists WHERE token = ?`, token,
+	).Scan(&playlist.Token, &playlist.TelegramUserID, &allFiles, &createdAt)
+	if err != nil {
+		return nil, err
+	}
+	if t, err := parseTimestamp(createdAt); err == nil {
+		playlist.CreatedAt = t
+	}
+	if allFiles == 1 {
+		return playlist, nil
+	}
+
+	rows, err := db.conn.Query(`SELECT file_id FROM playlist_files WHERE token = ? ORDER BY position`, token)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-		// Parse expires_at
-		if expiresAt.Valid && expiresAt.String != "" {
-			if t, err := time.Parse("2006-01-02 15:04:05", expiresAt.String); err == nil {
-				record.ExpiresAt = t
-			}
+	for rows.Next() {
+		var fileID string
+		if err := rows.Scan(&fileID); err != nil {
+			return nil, err
 		}
+		playlist.FileIDs = append(playlist.FileIDs, fileID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
-		record.IsProxied = isProxiedInt == 1
-		records = append(records, record)
+	// Every file of a subset playlist has expired and been deleted
+	if len(playlist.FileIDs) == 0 {
+		return nil, sql.ErrNoRows
 	}
+	return playlist, nil
+}
 
-	return records, rows.Err()
+// GetAllFilesPlaylist returns a user's playlist of all active files, so
+// repeated /playlist commands hand out the same link
+func (db *DB) GetAllFilesPlaylist(userID int64) (*Playlist, error) {
+	defer observeQuery("get_all_files_playlist")()
+
+	playlist := &Playlist{}
+	var createdAt string
+	err := db.conn.QueryRow(
+		`SELECT token, telegram_user_id, created_at FROM playlists WHERE telegram_user_id = ? AND all_files = 1 ORDER BY created_at LIMIT 1`, userID,
+	).Scan(&playlist.Token, &playlist.TelegramUserID, &createdAt)
+	if err != nil {
+		return nil, err
+	}
+	if t, err := parseTimestamp(createdAt); err == nil {
+		playlist.CreatedAt = t
+	}
+	return playlist, nil
+}
+
+// DeleteUserPlaylists revokes every playlist link a user has been given
+func (db *DB) DeleteUserPlaylists(userID int64) error {
+	defer observeQuery("delete_user_playlists")()
+
+	_, err := db.conn.Exec(`DELETE FROM playlists WHERE telegram_user_id = ?`, userID)
+	return err
+}
+
+// DeleteEmptyPlaylists removes subset playlists whose files have all been
+// deleted
+func (db *DB) DeleteEmptyPlaylists() (int64, error) {
+	defer observeQuery("delete_empty_playlists")()
+
+	result, err := db.conn.Exec(`
+	DELETE FROM playlists
+	WHERE all_files = 0 AND token NOT IN (SELECT token FROM playlist_files)
+	`)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
 
 func (db *DB) DeleteFile(id string) error {
+	defer observeQuery("delete_file")()
+
 	query := `DELETE FROM files WHERE id = ?`
 	_, err := db.conn.Exec(query, id)
 	return err
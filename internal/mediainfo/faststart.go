@@ -0,0 +1,334 @@
+package mediainfo
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+// FaststartLayout is a virtual view of an MP4 whose moov box comes after its
+// media data. The view puts moov first, with chunk offsets rewritten to match,
+// so players can start before fetching the end of the file.
+type FaststartLayout struct {
+	size     int64
+	segments []layoutSegment
+}
+
+// layoutSegment is a run of the virtual file, taken either from the original
+// file or from the rewritten moov in memory
+type layoutSegment struct {
+	offset int64 // Position in the virtual file
+	length int64
+	source int64 // Position in the original file (when data is nil)
+	data   []byte
+}
+
+// Size is the size of the virtual file. It grows if 32-bit chunk offsets had
+// to be widened to 64 bits.
+func (l *FaststartLayout) Size() int64 {
+	return l.size
+}
+
+// Reader returns the virtual file backed by the original file
+func (l *FaststartLayout) Reader(original io.ReaderAt) io.ReaderAt {
+	return &faststartReader{layout: l, original: original}
+}
+
+type faststartReader struct {
+	layout   *FaststartLayout
+	original io.ReaderAt
+}
+
+func (f *faststartReader) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for _, seg := range f.layout.segments {
+		if n == len(p) {
+			break
+		}
+		pos := off + int64(n)
+		if pos >= seg.offset+seg.length {
+			continue
+		}
+
+		within := pos - seg.offset
+		want := min(int64(len(p)-n), seg.length-within)
+		if seg.data != nil {
+			n += copy(p[n:n+int(want)], seg.data[within:])
+			continue
+		}
+		read, err := f.original.ReadAt(p[n:n+int(want)], seg.source+within)
+		n += read
+		if read < int(want) {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return n, err
+		}
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// boxNode is a parsed moov box. Only the containers leading to the chunk
+// offset tables are expanded; everything else is kept as opaque payload.
+type boxNode struct {
+	kind     string
+	payload  []byte
+	children []*boxNode
+}
+
+// faststartContainers are the boxes between moov and stco/co64
+var faststartContainers = map[string]bool{
+	"moov": true, "trak": true, "mdia": true, "minf": true, "stbl": true,
+}
+
+func parseBoxNodes(data []byte) ([]*boxNode, error) {
+	var nodes []*boxNode
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, errors.New("truncated box in moov")
+		}
+		size := uint64(binary.BigEndian.Uint32(data[:4]))
+		header := uint64(8)
+		if size == 1 {
+			if len(data) < 16 {
+				return nil, errors.New("truncated box in moov")
+			}
+			size = binary.BigEndian.Uint64(data[8:16])
+			header = 16
+		} else if size == 0 {
+			size = uint64(len(data))
+		}
+		if size < header || size > uint64(len(data)) {
+			return nil, errors.New("invalid box size in moov")
+		}
+
+		node := &boxNode{kind: string(data[4:8])}
+		if faststartContainers[node.kind] {
+			children, err := parseBoxNodes(data[header:size])
+			if err != nil {
+				return nil, err
+			}
+			node.children = children
+		} else {
+			node.payload = data[header:size]
+		}
+		nodes = append(nodes, node)
+		data = data[size:]
+	}
+	return nodes, nil
+}
+
+// size returns the serialized size of the box including its header
+func (n *boxNode) size() int64 {
+	body := int64(len(n.payload))
+	if faststartContainers[n.kind] {
+		body = 0
+		for _, child := range n.children {
+			body += child.size()
+		}
+	}
+	if body+8 > math.MaxUint32 {
+		return body + 16
+	}
+	return body + 8
+}
+
+func (n *boxNode) appendTo(buf []byte) []byte {
+	size := n.size()
+	if size > math.MaxUint32 {
+		buf = binary.BigEndian.AppendUint32(buf, 1)
+		buf = append(buf, n.kind...)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(size))
+	} else {
+		buf = binary.BigEndian.AppendUint32(buf, uint32(size))
+		buf = append(buf, n.kind...)
+	}
+
+	if faststartContainers[n.kind] {
+		for _, child := range n.children {
+			buf = child.appendTo(buf)
+		}
+		return buf
+	}
+	return append(buf, n.payload...)
+}
+
+// offsetTables returns the stco and co64 boxes below n
+func (n *boxNode) offsetTables() []*boxNode {
+	if n.kind == "stco" || n.kind == "co64" {
+		return []*boxNode{n}
+	}
+	var tables []*boxNode
+	for _, child := range n.children {
+		tables = append(tables, child.offsetTables()...)
+	}
+	return tables
+}
+
+// readOffsets decodes a stco or co64 table
+func readOffsets(table *boxNode) ([]uint64, error) {
+	if len(table.payload) < 8 {
+		return nil, errors.New("truncated chunk offset table")
+	}
+	count := int(binary.BigEndian.Uint32(table.payload[4:8]))
+	width := 4
+	if table.kind == "co64" {
+		width = 8
+	}
+	if len(table.payload) < 8+count*width {
+		return nil, errors.New("truncated chunk offset table")
+	}
+
+	offsets := make([]uint64, count)
+	for i := range offsets {
+		entry := table.payload[8+i*width:]
+		if width == 4 {
+			offsets[i] = uint64(binary.BigEndian.Uint32(entry))
+		} else {
+			offsets[i] = binary.BigEndian.Uint64(entry)
+		}
+	}
+	return offsets, nil
+}
+
+// writeOffsets replaces a table's payload, switching to co64 when wide is set
+func writeOffsets(table *boxNode, offsets []uint64, wide bool) {
+	header := table.payload[:4] // version and flags
+	payload := append([]byte{}, header...)
+	payload = binary.BigEndian.AppendUint32(payload, uint32(len(offsets)))
+	if wide {
+		table.kind = "co64"
+	}
+	for _, offset := range offsets {
+		if table.kind == "co64" {
+			payload = binary.BigEndian.AppendUint64(payload, offset)
+		} else {
+			payload = binary.BigEndian.AppendUint32(payload, uint32(offset))
+		}
+	}
+	table.payload = payload
+}
+
+// NewFaststartLayout builds the faststart view of an MP4. It returns nil when
+// the file isn't an MP4 or already has moov before its media data.
+func NewFaststartLayout(r io.ReaderAt, size int64) (*FaststartLayout, error) {
+	br := newBlockReader(r, size)
+
+	head := make([]byte, 12)
+	if n, _ := br.ReadAt(head, 0); n < len(head) || !isMP4(head) {
+		return nil, nil
+	}
+
+	// Find the moov box and where the media data starts
+	var moov box
+	mdatStart := int64(-1)
+	for off := int64(0); off+8 <= size; {
+		b, err := readBoxHeader(br, off, size)
+		if err != nil {
+			return nil, err
+		}
+		switch b.kind {
+		case "mdat":
+			if mdatStart < 0 {
+				mdatStart = b.offset
+			}
+		case "moov":
+			moov = b
+		case "moof":
+			// Fragmented files are already streamable
+			return nil, nil
+		}
+		off += b.size
+	}
+	if moov.kind == "" || mdatStart < 0 || moov.offset < mdatStart {
+		return nil, nil
+	}
+
+	data, err := readFull(br, moov.offset, moov.size, maxMoovSize)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := parseBoxNodes(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) != 1 || nodes[0].kind != "moov" {
+		return nil, errors.New("unexpected moov layout")
+	}
+	root := nodes[0]
+	for _, child := range root.children {
+		if child.kind == "cmov" {
+			return nil, nil // Compressed movie headers can't be rewritten
+		}
+	}
+
+	tables := root.offsetTables()
+	original := make([][]uint64, len(tables))
+	for i, table := range tables {
+		if original[i], err = readOffsets(table); err != nil {
+			return nil, err
+		}
+	}
+
+	moovEnd := moov.offset + moov.size
+	relocate := func(newMoovSize int64) ([][]uint64, bool) {
+		fits := true
+		shifted := make([][]uint64, len(original))
+		for i, offsets := range original {
+			shifted[i] = make([]uint64, len(offsets))
+			for j, offset := range offsets {
+				o := int64(offset)
+				switch {
+				case o >= moovEnd:
+					o += newMoovSize - moov.size
+				case o >= mdatStart:
+					o += newMoovSize
+				}
+				shifted[i][j] = uint64(o)
+				if o > math.MaxUint32 && tables[i].kind == "stco" {
+					fits = false
+				}
+			}
+		}
+		return shifted, fits
+	}
+
+	// Moving moov forward shifts the media data; 32-bit offsets that no longer
+	// fit force the tables to co64, which grows moov once more
+	shifted, fits := relocate(root.size())
+	wide := !fits
+	if wide {
+		for i, table := range tables {
+			writeOffsets(table, original[i], true)
+		}
+		shifted, _ = relocate(root.size())
+	}
+	for i, table := range tables {
+		writeOffsets(table, shifted[i], wide)
+	}
+	newMoov := root.appendTo(nil)
+
+	layout := &FaststartLayout{}
+	add := func(source, length int64, data []byte) {
+		if length <= 0 {
+			return
+		}
+		layout.segments = append(layout.segments, layoutSegment{
+			offset: layout.size,
+			length: length,
+			source: source,
+			data:   data,
+		})
+		layout.size += length
+	}
+	add(0, mdatStart, nil)
+	add(-1, int64(len(newMoov)), newMoov)
+	add(mdatStart, moov.offset-mdatStart, nil)
+	add(moovEnd, size-moovEnd, nil)
+	return layout, nil
+}
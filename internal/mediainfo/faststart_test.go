@@ -0,0 +1,326 @@
+package mediainfo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// syntheticFile is a file of generated bytes with real data written over
+// parts of it, so tests can use files larger than 4 GiB without storing them
+type syntheticFile struct {
+	size  int64
+	parts map[int64][]byte // By offset; parts don't overlap
+}
+
+func syntheticByte(off int64) byte {
+	return byte(off*31 + off>>9)
+}
+
+func (f *syntheticFile) ReadAt(p []byte, off int64) (int, error) {
+	if off >= f.size {
+		return 0, io.EOF
+	}
+	n := int(min(int64(len(p)), f.size-off))
+	for i := 0; i < n; i++ {
+		p[i] = syntheticByte(off + int64(i))
+	}
+	for start, data := range f.parts {
+		lo, hi := max(start, off), min(start+int64(len(data)), off+int64(n))
+		if lo < hi {
+			copy(p[lo-off:hi-off], data[lo-start:hi-start])
+		}
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func testStco(offsets ...uint64) []byte {
+	payload := binary.BigEndian.AppendUint32(make([]byte, 4), uint32(len(offsets)))
+	for _, offset := range offsets {
+		payload = binary.BigEndian.AppendUint32(payload, uint32(offset))
+	}
+	return mp4Box("stco", payload)
+}
+
+func testCo64(offsets ...uint64) []byte {
+	payload := binary.BigEndian.AppendUint32(make([]byte, 4), uint32(len(offsets)))
+	for _, offset := range offsets {
+		payload = binary.BigEndian.AppendUint64(payload, offset)
+	}
+	return mp4Box("co64", payload)
+}
+
+// chunkOffsets reads every chunk offset table of the file's moov box
+func chunkOffsets(t *testing.T, r io.ReaderAt, size int64) (kinds []string, offsets [][]uint64) {
+	t.Helper()
+	moov, err := findTopLevelBox(r, size, "moov")
+	if err != nil {
+		t.Fatalf("finding moov: %v", err)
+	}
+	data, err := readFull(r, moov.offset, moov.size, maxMoovSize)
+	if err != nil {
+		t.Fatalf("reading moov: %v", err)
+	}
+	nodes, err := parseBoxNodes(data)
+	if err != nil {
+		t.Fatalf("parsing moov: %v", err)
+	}
+	for _, table := range nodes[0].offsetTables() {
+		values, err := readOffsets(table)
+		if err != nil {
+			t.Fatalf("reading %s: %v", table.kind, err)
+		}
+		kinds = append(kinds, table.kind)
+		offsets = append(offsets, values)
+	}
+	return kinds, offsets
+}
+
+func TestNewFaststartLayout(t *testing.T) {
+	// Media data holding distinct bytes, so moved chunks can be told apart
+	media := make([]byte, 4096)
+	for i := range media {
+		media[i] = byte(i * 7)
+	}
+	ftyp := testFtyp()
+	free := mp4Box("free", make([]byte, 8))
+	mdatStart := uint64(len(ftyp) + len(free))
+	mdat := mp4Box("mdat", media)
+	chunk := func(i uint64) uint64 { return mdatStart + 8 + i*512 }
+
+	// A file over 4 GiB with moov at the end. Moving moov forward pushes the
+	// last 32-bit chunk offsets past 4 GiB, so stco has to become co64.
+	const largeMoovAt = 1<<32 + 4096
+	largeMoov := mp4Box("moov",
+		testMvhd(1000, 60000),
+		testTrak("vide", "avc1", "und", 1280, 720, testStco(40, 1<<31, math.MaxUint32-255, math.MaxUint32-7)),
+		testTrak("soun", "mp4a", "eng", 0, 0, testCo64(48, math.MaxUint32+64)),
+	)
+	large := &syntheticFile{
+		size: largeMoovAt + int64(len(largeMoov)),
+		parts: map[int64][]byte{
+			0:                ftyp,
+			int64(len(ftyp)): mp4LargeBoxHeader("mdat", largeMoovAt-uint64(len(ftyp))),
+			largeMoovAt:      largeMoov,
+		},
+	}
+
+	tests := []struct {
+		name      string
+		file      io.ReaderAt
+		size      int64
+		mdatStart int64
+		kinds     []string // Offset tables in the rewritten moov
+		grow      int64    // How much larger the layout is than the file
+	}{
+		{
+			name: "stco",
+			file: bytes.NewReader(bytes.Join([][]byte{ftyp, free, mdat, mp4Box("moov",
+				testMvhd(1000, 60000),
+				testTrak("vide", "avc1", "und", 1280, 720, testStco(chunk(0), chunk(2), chunk(4))),
+				testTrak("soun", "mp4a", "eng", 0, 0, testStco(chunk(1), chunk(3), chunk(5))),
+			)}, nil)),
+			mdatStart: int64(mdatStart),
+			kinds:     []string{"stco", "stco"},
+		},
+		{
+			name: "co64 with a box after moov",
+			file: bytes.NewReader(bytes.Join([][]byte{ftyp, free, mdat, mp4Box("moov",
+				testMvhd(1000, 60000),
+				testTrak("vide", "avc1", "und", 1280, 720, testCo64(chunk(0), chunk(7))),
+			), mp4Box("free", make([]byte, 100))}, nil)),
+			mdatStart: int64(mdatStart),
+			kinds:     []string{"co64"},
+		},
+		{
+			name:      "stco widened to co64",
+			file:      large,
+			size:      large.size,
+			mdatStart: int64(len(ftyp)),
+			kinds:     []string{"co64", "co64"},
+			grow:      4 * 4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			size := tt.size
+			if r, ok := tt.file.(*bytes.Reader); ok {
+				size = r.Size()
+			}
+			layout, err := NewFaststartLayout(tt.file, size)
+			if err != nil || layout == nil {
+				t.Fatalf("NewFaststartLayout() = %v, %v", layout, err)
+			}
+			if got := layout.Size(); got != size+tt.grow {
+				t.Errorf("Size() = %d, want %d", got, size+tt.grow)
+			}
+			virtual := layout.Reader(tt.file)
+
+			// The boxes before mdat stay in place and moov follows them
+			want := make([]byte, tt.mdatStart)
+			tt.file.ReadAt(want, 0)
+			head := make([]byte, tt.mdatStart+8)
+			if _, err := virtual.ReadAt(head, 0); err != nil {
+				t.Fatalf("reading the layout: %v", err)
+			}
+			if !bytes.Equal(head[:tt.mdatStart], want) || string(head[tt.mdatStart+4:]) != "moov" {
+				t.Errorf("layout starts with %q, want %q then moov", head, want)
+			}
+
+			// Every chunk offset still points at the same bytes
+			_, before := chunkOffsets(t, tt.file, size)
+			kinds, after := chunkOffsets(t, virtual, layout.Size())
+			if !reflect.DeepEqual(kinds, tt.kinds) {
+				t.Errorf("offset tables = %v, want %v", kinds, tt.kinds)
+			}
+			for i := range before {
+				for j := range before[i] {
+					want := make([]byte, 8)
+					got := make([]byte, 8)
+					tt.file.ReadAt(want, int64(before[i][j]))
+					virtual.ReadAt(got, int64(after[i][j]))
+					if !bytes.Equal(got, want) {
+						t.Errorf("chunk %d of table %d moved from %d to %d but reads %x, want %x",
+							j, i, before[i][j], after[i][j], got, want)
+					}
+				}
+			}
+
+			// The rewritten file still probes the same
+			wantInfo, _ := Probe(tt.file, size)
+			gotInfo, err := Probe(virtual, layout.Size())
+			if err != nil || !reflect.DeepEqual(gotInfo, wantInfo) {
+				t.Errorf("Probe() of the layout = %+v, %v, want %+v", gotInfo, err, wantInfo)
+			}
+		})
+	}
+}
+
+func TestFaststartLayoutReader(t *testing.T) {
+	media := make([]byte, 1000)
+	for i := range media {
+		media[i] = byte(i * 13)
+	}
+	ftyp := testFtyp()
+	mdat := mp4Box("mdat", media)
+	moov := mp4Box("moov", testMvhd(1000, 1000), testTrak("soun", "mp4a", "eng", 0, 0, testStco(uint64(len(ftyp)+8))))
+	tail := mp4Box("free", []byte("trailing data"))
+	file := bytes.Join([][]byte{ftyp, mdat, moov, tail}, nil)
+
+	layout, err := NewFaststartLayout(bytes.NewReader(file), int64(len(file)))
+	if err != nil || layout == nil {
+		t.Fatalf("NewFaststartLayout() = %v, %v", layout, err)
+	}
+	virtual := layout.Reader(bytes.NewReader(file))
+
+	whole := make([]byte, layout.Size())
+	if n, err := virtual.ReadAt(whole, 0); n != len(whole) || err != nil {
+		t.Fatalf("ReadAt(whole) = %d, %v", n, err)
+	}
+	newMoov := whole[len(ftyp) : len(ftyp)+len(moov)]
+	want := bytes.Join([][]byte{ftyp, newMoov, mdat, tail}, nil)
+	if !bytes.Equal(whole, want) {
+		t.Fatalf("layout isn't ftyp, moov, mdat, free")
+	}
+
+	// Reads crossing segment boundaries in small steps give the same bytes
+	for _, step := range []int{1, 7, 64, 1000} {
+		var got []byte
+		buf := make([]byte, step)
+		for off := int64(0); ; off += int64(step) {
+			n, err := virtual.ReadAt(buf, off)
+			got = append(got, buf[:n]...)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("ReadAt(%d bytes at %d) error = %v", step, off, err)
+			}
+		}
+		if !bytes.Equal(got, whole) {
+			t.Errorf("reading in steps of %d gives different bytes", step)
+		}
+	}
+
+	if n, err := virtual.ReadAt(make([]byte, 4), layout.Size()); n != 0 || err != io.EOF {
+		t.Errorf("ReadAt(end) = %d, %v, want 0, EOF", n, err)
+	}
+}
+
+func TestNewFaststartLayoutSkipsOrFails(t *testing.T) {
+	ftyp := testFtyp()
+	mdat := mp4Box("mdat", make([]byte, 64))
+	trak := testTrak("vide", "avc1", "und", 640, 480, testStco(uint64(len(ftyp)+8)))
+	moov := mp4Box("moov", testMvhd(1000, 1000), trak)
+
+	tests := []struct {
+		name    string
+		file    []byte
+		wantErr bool
+	}{
+		{
+			name: "not an MP4",
+			file: []byte("\x1a\x45\xdf\xa3 this is Matroska"),
+		},
+		{
+			name: "moov already first",
+			file: bytes.Join([][]byte{ftyp, moov, mdat}, nil),
+		},
+		{
+			name: "no media data",
+			file: bytes.Join([][]byte{ftyp, moov}, nil),
+		},
+		{
+			name: "fragmented",
+			file: bytes.Join([][]byte{ftyp, mdat, moov, mp4Box("moof", make([]byte, 16))}, nil),
+		},
+		{
+			name: "compressed movie header",
+			file: bytes.Join([][]byte{ftyp, mdat, mp4Box("moov", mp4Box("cmov", make([]byte, 32)))}, nil),
+		},
+		{
+			name:    "top-level box smaller than its header",
+			file:    bytes.Join([][]byte{ftyp, mdat, {0, 0, 0, 3, 'f', 'r', 'e', 'e'}, moov}, nil),
+			wantErr: true,
+		},
+		{
+			name: "box inside moov larger than moov",
+			file: bytes.Join([][]byte{ftyp, mdat, mp4Box("moov",
+				testMvhd(1000, 1000),
+				[]byte{0, 0, 1, 0, 't', 'r', 'a', 'k'},
+			)}, nil),
+			wantErr: true,
+		},
+		{
+			name:    "truncated box inside moov",
+			file:    bytes.Join([][]byte{ftyp, mdat, mp4Box("moov", testMvhd(1000, 1000), []byte{0, 0, 0})}, nil),
+			wantErr: true,
+		},
+		{
+			name: "offset table shorter than its count",
+			file: bytes.Join([][]byte{ftyp, mdat, mp4Box("moov",
+				testTrak("vide", "avc1", "und", 640, 480, mp4Box("stco", []byte{0, 0, 0, 0, 0, 0, 0, 5, 0, 0, 0, 28})),
+			)}, nil),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			layout, err := NewFaststartLayout(bytes.NewReader(tt.file), int64(len(tt.file)))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewFaststartLayout() error = %v, want error %v", err, tt.wantErr)
+			}
+			if layout != nil {
+				t.Errorf("NewFaststartLayout() = %+v, want nil", layout)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package mediainfo
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// HTTPReaderAt reads a remote file with ranged GET requests
+type HTTPReaderAt struct {
+	Client *http.Client
+	URL    string
+}
+
+func (h *HTTPReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, h.URL, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+int64(len(p))-1))
+
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// Server ignored the range - skip ahead
+		if _, err := io.CopyN(io.Discard, resp.Body, off); err != nil {
+			return 0, err
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		return 0, io.EOF
+	default:
+		return 0, fmt.Errorf("range request returned status %d", resp.StatusCode)
+	}
+
+	return io.ReadFull(resp.Body, p)
+}
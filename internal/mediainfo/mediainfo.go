@@ -0,0 +1,168 @@
+package mediainfo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// ErrUnsupported is returned for containers the probe doesn't understand
+var ErrUnsupported = errors.New("unsupported container")
+
+// Info describes a media file's container and tracks
+type Info struct {
+	Container      string   `json:"container"` // mp4, matroska or webm
+	Duration       float64  `json:"duration_seconds"`
+	Width          int      `json:"width,omitempty"`
+	Height         int      `json:"height,omitempty"`
+	VideoCodec     string   `json:"video_codec,omitempty"`
+	AudioCodec     string   `json:"audio_codec,omitempty"`
+	AudioLanguages []string `json:"audio_languages"`
+	SubtitleTracks []string `json:"subtitle_tracks"`
+}
+
+// Probe reads the container headers of a file through r. Only the parts of
+// the file holding metadata are read, so r may be backed by range requests.
+func Probe(r io.ReaderAt, size int64) (*Info, error) {
+	br := newBlockReader(r, size)
+
+	head := make([]byte, 12)
+	n, err := br.ReadAt(head, 0)
+	if n < len(head) {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("failed to read file header: %w", err)
+	}
+
+	switch {
+	case bytes.Equal(head[:4], ebmlMagic):
+		return probeMatroska(br, size)
+	case isMP4(head):
+		return probeMP4(br, size)
+	}
+	return nil, ErrUnsupported
+}
+
+// blockSize is how much is fetched at once; metadata reads are small and
+// clustered, so this keeps the number of upstream requests low
+const blockSize = 64 * 1024
+
+// maxBlocks bounds the memory used by one probe
+const maxBlocks = 64
+
+// blockReader caches aligned blocks of an underlying ReaderAt
+type blockReader struct {
+	r      io.ReaderAt
+	size   int64
+	blocks map[int64][]byte
+	order  []int64
+}
+
+func newBlockReader(r io.ReaderAt, size int64) *blockReader {
+	return &blockReader{r: r, size: size, blocks: make(map[int64][]byte)}
+}
+
+func (b *blockReader) block(index int64) ([]byte, error) {
+	if data, ok := b.blocks[index]; ok {
+		return data, nil
+	}
+
+	start := index * blockSize
+	length := min(int64(blockSize), b.size-start)
+	data := make([]byte, length)
+	n, err := b.r.ReadAt(data, start)
+	if n < len(data) {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	if len(b.order) >= maxBlocks {
+		delete(b.blocks, b.order[0])
+		b.order = b.order[1:]
+	}
+	b.blocks[index] = data
+	b.order = append(b.order, index)
+	return data, nil
+}
+
+func (b *blockReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= b.size {
+			return n, io.EOF
+		}
+		data, err := b.block(pos / blockSize)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], data[pos%blockSize:])
+	}
+	return n, nil
+}
+
+// readFull reads length bytes at off, refusing unreasonably large metadata
+func readFull(r io.ReaderAt, off, length, limit int64) ([]byte, error) {
+	if length < 0 || length > limit {
+		return nil, fmt.Errorf("metadata element of %d bytes at %d is too large", length, off)
+	}
+	data := make([]byte, length)
+	if _, err := r.ReadAt(data, off); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Probeable reports whether a file may be an MP4 or Matroska container worth
+// probing, judging by its name and MIME type
+func Probeable(fileName, mimeType string) bool {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".mp4", ".m4v", ".m4a", ".mov", ".mkv", ".mka", ".webm":
+		return true
+	}
+	return strings.HasPrefix(mimeType, "video/") || strings.HasPrefix(mimeType, "audio/")
+}
+
+// Known reports whether probing found a supported container
+func (i *Info) Known() bool {
+	return i != nil && i.Container != ""
+}
+
+// Resolution formats the video size as "1920x1080", or "" without video
+func (i *Info) Resolution() string {
+	if i.Width == 0 || i.Height == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%dx%d", i.Width, i.Height)
+}
+
+// Codecs formats the video and audio codecs as "h264 / aac"
+func (i *Info) Codecs() string {
+	var codecs []string
+	for _, codec := range []string{i.VideoCodec, i.AudioCodec} {
+		if codec != "" {
+			codecs = append(codecs, codec)
+		}
+	}
+	return strings.Join(codecs, " / ")
+}
+
+// FormatDuration formats seconds as "1:02:03" or "2:03"
+func FormatDuration(seconds float64) string {
+	total := int(seconds + 0.5)
+	h, m, s := total/3600, total/60%60, total%60
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%d:%02d", m, s)
+}
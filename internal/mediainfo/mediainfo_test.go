@@ -0,0 +1,322 @@
+package mediainfo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// mp4Box serializes an ISO BMFF box with a 32-bit size
+func mp4Box(kind string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	out := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(out, kind...), body...)
+}
+
+// mp4LargeBoxHeader is the header of a box with a 64-bit size
+func mp4LargeBoxHeader(kind string, size uint64) []byte {
+	out := binary.BigEndian.AppendUint32(nil, 1)
+	out = append(out, kind...)
+	return binary.BigEndian.AppendUint64(out, size)
+}
+
+func testFtyp() []byte {
+	return mp4Box("ftyp", []byte("isom\x00\x00\x02\x00isomiso2"))
+}
+
+func testMvhd(timescale, duration uint32) []byte {
+	payload := make([]byte, 100)
+	binary.BigEndian.PutUint32(payload[12:], timescale)
+	binary.BigEndian.PutUint32(payload[16:], duration)
+	return mp4Box("mvhd", payload)
+}
+
+func testMvhdV1(timescale uint32, duration uint64) []byte {
+	payload := make([]byte, 112)
+	payload[0] = 1
+	binary.BigEndian.PutUint32(payload[20:], timescale)
+	binary.BigEndian.PutUint64(payload[24:], duration)
+	return mp4Box("mvhd", payload)
+}
+
+// testTrak builds a track with the given handler, sample entry type and
+// language. Extra boxes go into stbl next to stsd.
+func testTrak(handler, sampleType, language string, width, height int, stbl ...[]byte) []byte {
+	tkhd := make([]byte, 84)
+	binary.BigEndian.PutUint32(tkhd[76:], uint32(width)<<16)
+	binary.BigEndian.PutUint32(tkhd[80:], uint32(height)<<16)
+
+	mdhd := make([]byte, 24)
+	if language != "" {
+		packed := uint16(language[0]-0x60)<<10 | uint16(language[1]-0x60)<<5 | uint16(language[2]-0x60)
+		binary.BigEndian.PutUint16(mdhd[20:], packed)
+	}
+
+	hdlr := make([]byte, 25)
+	copy(hdlr[8:], handler)
+
+	stsd := binary.BigEndian.AppendUint32(make([]byte, 4), 1)
+	stsd = append(stsd, mp4Box(sampleType, make([]byte, 8))...)
+
+	return mp4Box("trak",
+		mp4Box("tkhd", tkhd),
+		mp4Box("mdia",
+			mp4Box("mdhd", mdhd),
+			mp4Box("hdlr", hdlr),
+			mp4Box("minf", mp4Box("stbl", append([][]byte{mp4Box("stsd", stsd)}, stbl...)...)),
+		),
+	)
+}
+
+// ebmlElement serializes an EBML element with an 8-byte size
+func ebmlElement(id uint32, payload ...[]byte) []byte {
+	var out []byte
+	for shift := 24; shift >= 0; shift -= 8 {
+		if b := byte(id >> shift); b != 0 || len(out) > 0 {
+			out = append(out, b)
+		}
+	}
+	body := bytes.Join(payload, nil)
+	out = append(out, binary.BigEndian.AppendUint64(nil, uint64(len(body))|1<<56)...)
+	return append(out, body...)
+}
+
+// ebmlUnknownSize starts a master element whose size isn't known
+func ebmlUnknownSize(id uint32) []byte {
+	empty := ebmlElement(id)
+	return append(empty[:len(empty)-8], 0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF)
+}
+
+func ebmlUintElement(id uint32, value uint64) []byte {
+	return ebmlElement(id, binary.BigEndian.AppendUint64(nil, value))
+}
+
+func ebmlFloatElement(id uint32, value float64) []byte {
+	return ebmlElement(id, binary.BigEndian.AppendUint64(nil, math.Float64bits(value)))
+}
+
+func ebmlStringElement(id uint32, value string) []byte {
+	return ebmlElement(id, []byte(value))
+}
+
+func testEBMLHeader(docType string) []byte {
+	return ebmlElement(idEBML, ebmlUintElement(0x4286, 1), ebmlStringElement(idDocType, docType))
+}
+
+func testTrackEntry(trackType uint64, codecID string, fields ...[]byte) []byte {
+	return ebmlElement(idTrackEntry, append([][]byte{
+		ebmlUintElement(idTrackType, trackType),
+		ebmlStringElement(idCodecID, codecID),
+	}, fields...)...)
+}
+
+func testMatroskaInfo(durationMS float64) []byte {
+	return ebmlElement(idInfo, ebmlUintElement(idTimestampScale, 1000000), ebmlFloatElement(idDuration, durationMS))
+}
+
+func TestProbe(t *testing.T) {
+	movie := mp4Box("moov",
+		testMvhd(1000, 90500),
+		testTrak("vide", "avc1", "und", 1920, 1080),
+		testTrak("soun", "mp4a", "eng", 0, 0),
+		testTrak("soun", "ac-3", "jpn", 0, 0),
+		testTrak("text", "tx3g", "fre", 0, 0),
+	)
+	movieInfo := &Info{
+		Container:      "mp4",
+		Duration:       90.5,
+		Width:          1920,
+		Height:         1080,
+		VideoCodec:     "h264",
+		AudioCodec:     "aac",
+		AudioLanguages: []string{"eng", "jpn"},
+		SubtitleTracks: []string{"fre"},
+	}
+	mdat := mp4Box("mdat", make([]byte, 1000))
+
+	tracks := ebmlElement(idTracks,
+		testTrackEntry(trackVideo, "V_MPEG4/ISO/AVC", ebmlElement(idVideo,
+			ebmlUintElement(idPixelWidth, 1280),
+			ebmlUintElement(idPixelHeight, 720),
+		)),
+		testTrackEntry(trackAudio, "A_AAC/MPEG4/LC", ebmlStringElement(idLanguage, "jpn")),
+		testTrackEntry(trackAudio, "A_AC3"),
+		testTrackEntry(trackSubtitle, "S_TEXT/UTF8", ebmlStringElement(idLanguage, "eng"), ebmlStringElement(idName, "Forced")),
+		testTrackEntry(trackSubtitle, "S_TEXT/ASS", ebmlStringElement(idLanguage, "por"), ebmlStringElement(idLanguageIETF, "pt-BR")),
+	)
+	tracksInfo := &Info{
+		Container:      "matroska",
+		Duration:       5,
+		Width:          1280,
+		Height:         720,
+		VideoCodec:     "h264",
+		AudioCodec:     "aac",
+		AudioLanguages: []string{"jpn", "eng"},
+		SubtitleTracks: []string{"eng (Forced)", "pt-BR"},
+	}
+	cluster := ebmlElement(idCluster, make([]byte, 500))
+
+	// Info and Tracks after the first cluster are found through the SeekHead
+	seekHead := func(infoPos, tracksPos uint64) []byte {
+		return ebmlElement(idSeekHead,
+			ebmlElement(idSeek, ebmlUintElement(idSeekID, idInfo), ebmlUintElement(idSeekPosition, infoPos)),
+			ebmlElement(idSeek, ebmlUintElement(idSeekID, idTracks), ebmlUintElement(idSeekPosition, tracksPos)),
+		)
+	}
+	infoPos := uint64(len(seekHead(0, 0)) + len(cluster))
+	tracksPos := infoPos + uint64(len(testMatroskaInfo(5000)))
+	seeking := ebmlElement(idSegment, seekHead(infoPos, tracksPos), cluster, testMatroskaInfo(5000), tracks)
+
+	tests := []struct {
+		name string
+		file []byte
+		want *Info
+	}{
+		{
+			name: "mp4 with moov first",
+			file: bytes.Join([][]byte{testFtyp(), movie, mdat}, nil),
+			want: movieInfo,
+		},
+		{
+			name: "mp4 with moov after mdat",
+			file: bytes.Join([][]byte{testFtyp(), mdat, movie}, nil),
+			want: movieInfo,
+		},
+		{
+			name: "mp4 with a 64-bit mdat size",
+			file: bytes.Join([][]byte{testFtyp(), mp4LargeBoxHeader("mdat", 16+100), make([]byte, 100), movie}, nil),
+			want: movieInfo,
+		},
+		{
+			name: "mp4 with a version 1 mvhd and no ftyp",
+			file: mp4Box("moov", testMvhdV1(90000, 90000*3600*3), testTrak("vide", "hvc1", "", 3840, 2160)),
+			want: &Info{Container: "mp4", Duration: 3 * 3600, Width: 3840, Height: 2160, VideoCodec: "hevc"},
+		},
+		{
+			name: "mp4 with a moov running to the end of the file",
+			file: append(append(testFtyp(), 0, 0, 0, 0, 'm', 'o', 'o', 'v'), testMvhd(600, 1200)...),
+			want: &Info{Container: "mp4", Duration: 2},
+		},
+		{
+			name: "matroska",
+			file: bytes.Join([][]byte{testEBMLHeader("matroska"), ebmlElement(idSegment, testMatroskaInfo(5000), tracks, cluster)}, nil),
+			want: tracksInfo,
+		},
+		{
+			name: "matroska with info after the first cluster",
+			file: bytes.Join([][]byte{testEBMLHeader("matroska"), seeking}, nil),
+			want: tracksInfo,
+		},
+		{
+			name: "webm with a segment of unknown size",
+			file: bytes.Join([][]byte{
+				testEBMLHeader("webm"),
+				ebmlUnknownSize(idSegment),
+				ebmlElement(idInfo, ebmlFloatElement(idDuration, 1500)),
+				ebmlElement(idTracks,
+					testTrackEntry(trackVideo, "V_VP9"),
+					testTrackEntry(trackAudio, "A_OPUS", ebmlStringElement(idLanguage, "ger")),
+				),
+				ebmlUnknownSize(idCluster),
+			}, nil),
+			want: &Info{Container: "webm", Duration: 1.5, VideoCodec: "vp9", AudioCodec: "opus", AudioLanguages: []string{"ger"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Probe(bytes.NewReader(tt.file), int64(len(tt.file)))
+			if err != nil {
+				t.Fatalf("Probe() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Probe() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProbeErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		file        []byte
+		unsupported bool
+	}{
+		{
+			name: "too short for a header",
+			file: []byte("\x00\x00\x00\x08ftyp"),
+		},
+		{
+			name:        "unknown container",
+			file:        []byte("RIFF\x00\x00\x00\x00AVI LIST\x00\x00\x00\x00"),
+			unsupported: true,
+		},
+		{
+			name: "box smaller than its header",
+			file: append(testFtyp(), 0, 0, 0, 4, 'f', 'r', 'e', 'e', 0, 0, 0, 0),
+		},
+		{
+			name: "box running past the end of the file",
+			file: append(testFtyp(), mp4Box("mdat", make([]byte, 100))[:50]...),
+		},
+		{
+			name: "truncated 64-bit box size",
+			file: append(testFtyp(), 0, 0, 0, 1, 'm', 'd', 'a', 't', 0, 0),
+		},
+		{
+			name: "moov too large to load",
+			file: append(testFtyp(), mp4LargeBoxHeader("moov", maxMoovSize+17)...),
+		},
+		{
+			name: "invalid EBML size",
+			file: append([]byte{0x1A, 0x45, 0xDF, 0xA3, 0x00}, make([]byte, 16)...),
+		},
+		{
+			name: "EBML header without a segment",
+			file: append(testEBMLHeader("matroska"), ebmlElement(idCluster, make([]byte, 10))...),
+		},
+		{
+			name: "Matroska tracks too large to load",
+			file: bytes.Join([][]byte{
+				testEBMLHeader("matroska"),
+				ebmlUnknownSize(idSegment),
+				// 32 MiB of tracks
+				{0x16, 0x54, 0xAE, 0x6B, 0x01, 0x00, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00},
+			}, nil),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := Probe(bytes.NewReader(tt.file), int64(len(tt.file)))
+			if err == nil {
+				t.Fatalf("Probe() = %+v, want an error", info)
+			}
+			if got := errors.Is(err, ErrUnsupported); got != tt.unsupported {
+				t.Errorf("Probe() error = %v, unsupported = %v, want %v", err, got, tt.unsupported)
+			}
+		})
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := []struct {
+		seconds float64
+		want    string
+	}{
+		{0, "0:00"},
+		{59.6, "1:00"},
+		{125, "2:05"},
+		{3600, "1:00:00"},
+		{7384.2, "2:03:04"},
+	}
+
+	for _, tt := range tests {
+		if got := FormatDuration(tt.seconds); got != tt.want {
+			t.Errorf("FormatDuration(%v) = %q, want %q", tt.seconds, got, tt.want)
+		}
+	}
+}
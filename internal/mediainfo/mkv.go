@@ -0,0 +1,342 @@
+package mediainfo
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// EBML element IDs used by the probe
+const (
+	idEBML           = 0x1A45DFA3
+	idDocType        = 0x4282
+	idSegment        = 0x18538067
+	idSeekHead       = 0x114D9B74
+	idSeek           = 0x4DBB
+	idSeekID         = 0x53AB
+	idSeekPosition   = 0x53AC
+	idInfo           = 0x1549A966
+	idTimestampScale = 0x2AD7B1
+	idDuration       = 0x4489
+	idTracks         = 0x1654AE6B
+	idTrackEntry     = 0xAE
+	idTrackType      = 0x83
+	idCodecID        = 0x86
+	idLanguage       = 0x22B59C
+	idLanguageIETF   = 0x22B59D
+	idName           = 0x536E
+	idVideo          = 0xE0
+	idPixelWidth     = 0xB0
+	idPixelHeight    = 0xBA
+	idCluster        = 0x1F43B675
+)
+
+// Matroska track types
+const (
+	trackVideo    = 1
+	trackAudio    = 2
+	trackSubtitle = 17
+)
+
+// maxMatroskaElement bounds the size of a metadata element loaded into memory
+const maxMatroskaElement = 16 * 1024 * 1024
+
+var ebmlMagic = []byte{0x1A, 0x45, 0xDF, 0xA3}
+
+// element is an EBML element header
+type element struct {
+	id         uint32
+	dataOffset int64
+	size       int64 // -1 when unknown
+}
+
+// readVint reads an EBML variable-length integer. IDs keep their length
+// marker bit, sizes don't.
+func readVint(r io.ReaderAt, off int64, keepMarker bool) (uint64, int, error) {
+	var buf [8]byte
+	if _, err := r.ReadAt(buf[:1], off); err != nil {
+		return 0, 0, err
+	}
+
+	length := 1
+	for mask := byte(0x80); length <= 8 && buf[0]&mask == 0; mask >>= 1 {
+		length++
+	}
+	if length > 8 {
+		return 0, 0, fmt.Errorf("invalid EBML integer at %d", off)
+	}
+	if length > 1 {
+		if n, err := r.ReadAt(buf[1:length], off+1); n < length-1 {
+			return 0, 0, err
+		}
+	}
+
+	value := uint64(buf[0])
+	if !keepMarker {
+		value &= uint64(0xFF >> length)
+	}
+	for i := 1; i < length; i++ {
+		value = value<<8 | uint64(buf[i])
+	}
+	return value, length, nil
+}
+
+// readElement reads the element header at off
+func readElement(r io.ReaderAt, off int64) (element, error) {
+	id, idLen, err := readVint(r, off, true)
+	if err != nil {
+		return element{}, err
+	}
+	size, sizeLen, err := readVint(r, off+int64(idLen), false)
+	if err != nil {
+		return element{}, err
+	}
+
+	e := element{id: uint32(id), dataOffset: off + int64(idLen+sizeLen), size: int64(size)}
+	// All value bits set means "unknown size"
+	if size == 1<<(7*sizeLen)-1 {
+		e.size = -1
+	}
+	return e, nil
+}
+
+// ebmlChildren parses the elements inside an in-memory master element
+func ebmlChildren(data []byte) map[uint32][][]byte {
+	r := byteReaderAt(data)
+	elements := make(map[uint32][][]byte)
+	for off := int64(0); off < int64(len(data)); {
+		e, err := readElement(r, off)
+		if err != nil || e.size < 0 || e.dataOffset+e.size > int64(len(data)) {
+			break
+		}
+		elements[e.id] = append(elements[e.id], data[e.dataOffset:e.dataOffset+e.size])
+		off = e.dataOffset + e.size
+	}
+	return elements
+}
+
+// byteReaderAt adapts a byte slice for readVint
+type byteReaderAt []byte
+
+func (b byteReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(b)) {
+		return 0, io.EOF
+	}
+	n := copy(p, b[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func ebmlUint(data []byte) uint64 {
+	var value uint64
+	for _, b := range data {
+		value = value<<8 | uint64(b)
+	}
+	return value
+}
+
+func ebmlFloat(data []byte) float64 {
+	switch len(data) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data)))
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(data))
+	}
+	return 0
+}
+
+func ebmlString(data []byte) string {
+	return strings.TrimRight(string(data), "\x00")
+}
+
+func firstElement(elements map[uint32][][]byte, id uint32) []byte {
+	if found := elements[id]; len(found) > 0 {
+		return found[0]
+	}
+	return nil
+}
+
+func probeMatroska(r io.ReaderAt, size int64) (*Info, error) {
+	header, err := readElement(r, 0)
+	if err != nil || header.id != idEBML || header.size < 0 {
+		return nil, fmt.Errorf("invalid EBML header: %v", err)
+	}
+	headerData, err := readFull(r, header.dataOffset, header.size, maxMatroskaElement)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &Info{Container: "matroska"}
+	if docType := ebmlString(firstElement(ebmlChildren(headerData), idDocType)); docType == "webm" {
+		info.Container = "webm"
+	}
+
+	segment, err := readElement(r, header.dataOffset+header.size)
+	if err != nil || segment.id != idSegment {
+		return nil, fmt.Errorf("no Matroska segment found: %v", err)
+	}
+	segmentEnd := size
+	if segment.size >= 0 {
+		segmentEnd = min(size, segment.dataOffset+segment.size)
+	}
+
+	// Walk the top-level elements until the first cluster. Info and Tracks
+	// normally come first; otherwise the SeekHead says where they are.
+	var infoData, tracksData []byte
+	seeks := make(map[uint32]int64)
+	for off := segment.dataOffset; off < segmentEnd && (infoData == nil || tracksData == nil); {
+		e, err := readElement(r, off)
+		if err != nil {
+			return nil, err
+		}
+		if e.id == idCluster || e.size < 0 {
+			break
+		}
+
+		switch e.id {
+		case idInfo:
+			infoData, err = readFull(r, e.dataOffset, e.size, maxMatroskaElement)
+		case idTracks:
+			tracksData, err = readFull(r, e.dataOffset, e.size, maxMatroskaElement)
+		case idSeekHead:
+			var data []byte
+			data, err = readFull(r, e.dataOffset, e.size, maxMatroskaElement)
+			for _, seek := range ebmlChildren(data)[idSeek] {
+				fields := ebmlChildren(seek)
+				id := uint32(ebmlUint(firstElement(fields, idSeekID)))
+				seeks[id] = segment.dataOffset + int64(ebmlUint(firstElement(fields, idSeekPosition)))
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		off = e.dataOffset + e.size
+	}
+
+	if infoData == nil {
+		infoData = readSeekTarget(r, seeks, idInfo)
+	}
+	if tracksData == nil {
+		tracksData = readSeekTarget(r, seeks, idTracks)
+	}
+
+	if infoData != nil {
+		fields := ebmlChildren(infoData)
+		scale := uint64(1000000)
+		if data := firstElement(fields, idTimestampScale); data != nil {
+			scale = ebmlUint(data)
+		}
+		info.Duration = ebmlFloat(firstElement(fields, idDuration)) * float64(scale) / 1e9
+	}
+
+	if tracksData != nil {
+		for _, entry := range ebmlChildren(tracksData)[idTrackEntry] {
+			parseTrackEntry(info, ebmlChildren(entry))
+		}
+	}
+	return info, nil
+}
+
+// readSeekTarget loads a top-level element the SeekHead points at
+func readSeekTarget(r io.ReaderAt, seeks map[uint32]int64, id uint32) []byte {
+	off, ok := seeks[id]
+	if !ok {
+		return nil
+	}
+	e, err := readElement(r, off)
+	if err != nil || e.id != id || e.size < 0 {
+		return nil
+	}
+	data, err := readFull(r, e.dataOffset, e.size, maxMatroskaElement)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+func parseTrackEntry(info *Info, fields map[uint32][][]byte) {
+	// Matroska's default language is English
+	language := "eng"
+	if data := firstElement(fields, idLanguage); data != nil {
+		language = ebmlString(data)
+	}
+	if data := firstElement(fields, idLanguageIETF); data != nil {
+		language = ebmlString(data)
+	}
+	codec := matroskaCodec(ebmlString(firstElement(fields, idCodecID)))
+
+	switch ebmlUint(firstElement(fields, idTrackType)) {
+	case trackVideo:
+		if info.VideoCodec != "" {
+			return
+		}
+		info.VideoCodec = codec
+		video := ebmlChildren(firstElement(fields, idVideo))
+		info.Width = int(ebmlUint(firstElement(video, idPixelWidth)))
+		info.Height = int(ebmlUint(firstElement(video, idPixelHeight)))
+	case trackAudio:
+		if info.AudioCodec == "" {
+			info.AudioCodec = codec
+		}
+		info.AudioLanguages = append(info.AudioLanguages, language)
+	case trackSubtitle:
+		track := language
+		if name := ebmlString(firstElement(fields, idName)); name != "" {
+			track += " (" + name + ")"
+		}
+		info.SubtitleTracks = append(info.SubtitleTracks, track)
+	}
+}
+
+// matroskaCodec maps a Matroska codec ID to a common codec name
+func matroskaCodec(codecID string) string {
+	switch {
+	case codecID == "V_MPEG4/ISO/AVC":
+		return "h264"
+	case codecID == "V_MPEGH/ISO/HEVC":
+		return "hevc"
+	case codecID == "V_AV1":
+		return "av1"
+	case codecID == "V_VP8":
+		return "vp8"
+	case codecID == "V_VP9":
+		return "vp9"
+	case strings.HasPrefix(codecID, "V_MPEG4/"):
+		return "mpeg4"
+	case codecID == "V_MPEG2":
+		return "mpeg2"
+	case strings.HasPrefix(codecID, "A_AAC"):
+		return "aac"
+	case codecID == "A_AC3":
+		return "ac3"
+	case codecID == "A_EAC3":
+		return "eac3"
+	case strings.HasPrefix(codecID, "A_DTS"):
+		return "dts"
+	case codecID == "A_TRUEHD":
+		return "truehd"
+	case codecID == "A_OPUS":
+		return "opus"
+	case codecID == "A_VORBIS":
+		return "vorbis"
+	case codecID == "A_FLAC":
+		return "flac"
+	case codecID == "A_MPEG/L3":
+		return "mp3"
+	case codecID == "S_TEXT/UTF8":
+		return "srt"
+	case codecID == "S_TEXT/ASS", codecID == "S_TEXT/SSA":
+		return "ass"
+	case codecID == "S_TEXT/WEBVTT":
+		return "webvtt"
+	case codecID == "S_HDMV/PGS":
+		return "pgs"
+	case codecID == "S_VOBSUB":
+		return "vobsub"
+	}
+	return strings.ToLower(codecID)
+}
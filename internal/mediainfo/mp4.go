@@ -0,0 +1,267 @@
+package mediainfo
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// maxMoovSize bounds how much of an MP4 index is loaded into memory
+const maxMoovSize = 64 * 1024 * 1024
+
+// isMP4 reports whether a file starts like an ISO BMFF file
+func isMP4(head []byte) bool {
+	switch string(head[4:8]) {
+	case "ftyp", "moov", "mdat", "free", "skip", "wide":
+		return true
+	}
+	return false
+}
+
+// box is an ISO BMFF box found in a buffer or file
+type box struct {
+	kind       string
+	offset     int64 // Start of the box header
+	headerSize int64
+	size       int64 // Including the header
+}
+
+// readBoxHeader reads the box header at off
+func readBoxHeader(r io.ReaderAt, off, fileSize int64) (box, error) {
+	head := make([]byte, 16)
+	n, err := r.ReadAt(head[:8], off)
+	if n < 8 {
+		return box{}, fmt.Errorf("truncated box header at %d: %w", off, err)
+	}
+
+	b := box{kind: string(head[4:8]), offset: off, headerSize: 8}
+	size := int64(binary.BigEndian.Uint32(head[:4]))
+	switch size {
+	case 0:
+		// Box runs to the end of the file
+		size = fileSize - off
+	case 1:
+		if n, err := r.ReadAt(head[8:16], off+8); n < 8 {
+			return box{}, fmt.Errorf("truncated box size at %d: %w", off, err)
+		}
+		size = int64(binary.BigEndian.Uint64(head[8:16]))
+		b.headerSize = 16
+	}
+	if size < b.headerSize {
+		return box{}, fmt.Errorf("invalid %q box size %d at %d", b.kind, size, off)
+	}
+	b.size = size
+	return b, nil
+}
+
+// findTopLevelBox walks the top-level boxes for one of the given kind
+func findTopLevelBox(r io.ReaderAt, fileSize int64, kind string) (box, error) {
+	for off := int64(0); off+8 <= fileSize; {
+		b, err := readBoxHeader(r, off, fileSize)
+		if err != nil {
+			return box{}, err
+		}
+		if b.kind == kind {
+			return b, nil
+		}
+		off += b.size
+	}
+	return box{}, fmt.Errorf("no %q box found", kind)
+}
+
+// children returns the boxes inside a buffer holding box payloads
+func children(data []byte) map[string][][]byte {
+	boxes := make(map[string][][]byte)
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data[:4]))
+		kind := string(data[4:8])
+		header := uint64(8)
+		if size == 1 && len(data) >= 16 {
+			size = binary.BigEndian.Uint64(data[8:16])
+			header = 16
+		} else if size == 0 {
+			size = uint64(len(data))
+		}
+		if size < header || size > uint64(len(data)) {
+			break
+		}
+		boxes[kind] = append(boxes[kind], data[header:size])
+		data = data[size:]
+	}
+	return boxes
+}
+
+// first returns the first child box of a kind, or nil
+func first(boxes map[string][][]byte, kind string) []byte {
+	if found := boxes[kind]; len(found) > 0 {
+		return found[0]
+	}
+	return nil
+}
+
+func probeMP4(r io.ReaderAt, size int64) (*Info, error) {
+	moov, err := findTopLevelBox(r, size, "moov")
+	if err != nil {
+		return nil, err
+	}
+	data, err := readFull(r, moov.offset+moov.headerSize, moov.size-moov.headerSize, maxMoovSize)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &Info{Container: "mp4"}
+	boxes := children(data)
+	if mvhd := first(boxes, "mvhd"); mvhd != nil {
+		timescale, duration := parseMediaTime(mvhd)
+		if timescale > 0 {
+			info.Duration = float64(duration) / float64(timescale)
+		}
+	}
+
+	for _, trak := range boxes["trak"] {
+		parseTrak(info, children(trak))
+	}
+	return info, nil
+}
+
+// parseMediaTime reads timescale and duration from an mvhd or mdhd payload
+func parseMediaTime(data []byte) (timescale uint32, duration uint64) {
+	if len(data) < 4 {
+		return 0, 0
+	}
+	if data[0] == 1 {
+		if len(data) < 32 {
+			return 0, 0
+		}
+		return binary.BigEndian.Uint32(data[20:24]), binary.BigEndian.Uint64(data[24:32])
+	}
+	if len(data) < 20 {
+		return 0, 0
+	}
+	return binary.BigEndian.Uint32(data[12:16]), uint64(binary.BigEndian.Uint32(data[16:20]))
+}
+
+func parseTrak(info *Info, trak map[string][][]byte) {
+	mdia := children(first(trak, "mdia"))
+
+	handler := ""
+	if hdlr := first(mdia, "hdlr"); len(hdlr) >= 12 {
+		handler = string(hdlr[8:12])
+	}
+
+	language := "und"
+	if mdhd := first(mdia, "mdhd"); mdhd != nil {
+		language = mdhdLanguage(mdhd)
+	}
+
+	codec := ""
+	stbl := children(first(children(first(mdia, "minf")), "stbl"))
+	stsd := first(stbl, "stsd")
+	if len(stsd) >= 16 {
+		codec = mp4Codec(string(stsd[12:16]))
+	}
+
+	switch handler {
+	case "vide":
+		if info.VideoCodec != "" {
+			return
+		}
+		info.VideoCodec = codec
+		if tkhd := first(trak, "tkhd"); tkhd != nil {
+			info.Width, info.Height = tkhdDimensions(tkhd)
+		}
+		// Fall back to the sample entry's coded size
+		if (info.Width == 0 || info.Height == 0) && len(stsd) >= 8+36 {
+			entry := stsd[8:]
+			info.Width = int(binary.BigEndian.Uint16(entry[32:34]))
+			info.Height = int(binary.BigEndian.Uint16(entry[34:36]))
+		}
+	case "soun":
+		if info.AudioCodec == "" {
+			info.AudioCodec = codec
+		}
+		info.AudioLanguages = append(info.AudioLanguages, language)
+	case "sbtl", "subt", "text", "clcp":
+		info.SubtitleTracks = append(info.SubtitleTracks, language)
+	}
+}
+
+// tkhdDimensions reads the 16.16 fixed-point track width and height
+func tkhdDimensions(tkhd []byte) (int, int) {
+	off := 76
+	if len(tkhd) > 0 && tkhd[0] == 1 {
+		off = 88
+	}
+	if len(tkhd) < off+8 {
+		return 0, 0
+	}
+	width := binary.BigEndian.Uint32(tkhd[off : off+4])
+	height := binary.BigEndian.Uint32(tkhd[off+4 : off+8])
+	return int(width >> 16), int(height >> 16)
+}
+
+// mdhdLanguage unpacks the ISO 639-2 code stored as three 5-bit letters
+func mdhdLanguage(mdhd []byte) string {
+	off := 20
+	if len(mdhd) > 0 && mdhd[0] == 1 {
+		off = 32
+	}
+	if len(mdhd) < off+2 {
+		return "und"
+	}
+	packed := binary.BigEndian.Uint16(mdhd[off : off+2])
+	if packed == 0 || packed == 0x7FFF {
+		return "und"
+	}
+	letters := []byte{
+		byte(packed>>10&0x1F) + 0x60,
+		byte(packed>>5&0x1F) + 0x60,
+		byte(packed&0x1F) + 0x60,
+	}
+	for _, c := range letters {
+		if c < 'a' || c > 'z' {
+			return "und"
+		}
+	}
+	return string(letters)
+}
+
+// mp4Codec maps a sample entry type to a common codec name
+func mp4Codec(fourcc string) string {
+	switch fourcc {
+	case "avc1", "avc3":
+		return "h264"
+	case "hvc1", "hev1":
+		return "hevc"
+	case "av01":
+		return "av1"
+	case "vp08":
+		return "vp8"
+	case "vp09":
+		return "vp9"
+	case "mp4v":
+		return "mpeg4"
+	case "mp4a":
+		return "aac"
+	case "ac-3":
+		return "ac3"
+	case "ec-3":
+		return "eac3"
+	case "Opus":
+		return "opus"
+	case "fLaC":
+		return "flac"
+	case ".mp3":
+		return "mp3"
+	case "alac":
+		return "alac"
+	case "tx3g":
+		return "mov_text"
+	case "wvtt":
+		return "webvtt"
+	case "stpp":
+		return "ttml"
+	}
+	return strings.TrimSpace(fourcc)
+}
@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Handler serves the Prometheus text format. When token is set it must be
+// sent as "Authorization: Bearer <token>".
+func Handler(token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token != "" {
+			got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if err := WriteText(w); err != nil {
+			log.Printf("Error writing metrics: %v", err)
+		}
+	}
+}
+
+// Listen serves /metrics on its own address so it can be bound to localhost
+// or a private interface. The returned server must be shut down when done.
+func Listen(addr, token string) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", Handler(token))
+	metricsServer := &http.Server{Addr: addr, Handler: mux}
+
+	go func() {
+		log.Printf("Starting metrics server on %s", addr)
+		if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("Metrics server error: %v", err)
+		}
+	}()
+	return metricsServer
+}
@@ -0,0 +1,59 @@
+// Package metrics exposes the bot's and server's counters in the Prometheus
+// text format without pulling in the Prometheus client library.
+package metrics
+
+var (
+	ActiveStreams = NewGaugeVec(
+		"hafton_active_streams",
+		"Responses currently being served, by endpoint and source.",
+		"endpoint", "source",
+	)
+	BytesServed = NewCounterVec(
+		"hafton_bytes_served_total",
+		"Response body bytes written, by endpoint and source.",
+		"endpoint", "source",
+	)
+	UpstreamDuration = NewHistogramVec(
+		"hafton_upstream_request_duration_seconds",
+		"Time until Telegram returned response headers for proxied files.",
+		DefaultBuckets,
+	)
+	UpstreamErrors = NewCounterVec(
+		"hafton_upstream_errors_total",
+		"Failed requests to Telegram for proxied files, by reason.",
+		"reason",
+	)
+	UpstreamResumes = NewCounterVec(
+		"hafton_upstream_resumes_total",
+		"Interrupted Telegram transfers continued with a new ranged request, by outcome.",
+		"outcome",
+	)
+	SharedChunks = NewCounterVec(
+		"hafton_shared_chunk_requests_total",
+		"Chunk reads through the coalescing buffer, by whether they were buffered, joined a fetch in flight or fetched.",
+		"result",
+	)
+	FilesIngested = NewCounterVec(
+		"hafton_files_ingested_total",
+		"Files registered by the bot, by media type.",
+		"media_type",
+	)
+	CleanupRuns = NewCounterVec(
+		"hafton_cleanup_runs_total",
+		"Completed cleanup passes.",
+	)
+	CleanupFailures = NewCounterVec(
+		"hafton_cleanup_failures_total",
+		"Cleanup passes that could not look up expired files.",
+	)
+	CleanupDeletions = NewCounterVec(
+		"hafton_cleanup_deleted_files_total",
+		"Expired files removed by cleanup.",
+	)
+	DBQueryDuration = NewHistogramVec(
+		"hafton_db_query_duration_seconds",
+		"Database query latency, by query.",
+		[]float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+		"query",
+	)
+)
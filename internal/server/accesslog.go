@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"hafton-movie-bot/internal/accesslog"
+	"hafton-movie-bot/internal/config"
+
+	"github.com/gorilla/mux"
+)
+
+// accessEntry is one line of the JSON access log
+type accessEntry struct {
+	Time           string  `json:"time"`
+	RequestID      string  `json:"request_id"`
+	ClientIP       string  `json:"client_ip"`
+	Method         string  `json:"method"`
+	Path           string  `json:"path"`
+	FileID         string  `json:"file_id,omitempty"`
+	RangeRequested string  `json:"range_requested,omitempty"`
+	RangeServed    string  `json:"range_served,omitempty"`
+	Status         int     `json:"status"`
+	Bytes          int64   `json:"bytes"`
+	DurationMS     float64 `json:"duration_ms"`
+	UpstreamStatus int     `json:"upstream_status,omitempty"`
+	UserAgent      string  `json:"user_agent,omitempty"`
+
+	mu sync.Mutex
+}
+
+type accessEntryKey struct{}
+
+// setUpstreamStatus records Telegram's status code for the access log entry
+// of the request ctx belongs to, if there is one
+func setUpstreamStatus(ctx context.Context, status int) {
+	entry, ok := ctx.Value(accessEntryKey{}).(*accessEntry)
+	if !ok {
+		return
+	}
+	entry.mu.Lock()
+	entry.UpstreamStatus = status
+	entry.mu.Unlock()
+}
+
+// loggingResponseWriter captures what was sent for the access log
+type loggingResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	rangeServed string
+}
+
+func (w *loggingResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+		w.rangeServed = w.Header().Get("Content-Range")
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *loggingResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// openAccessLog opens the configured access log, or returns nil when access
+// logging is disabled or the file can't be opened
+func openAccessLog(cfg *config.Config) *accesslog.File {
+	if !cfg.AccessLog.Enabled {
+		return nil
+	}
+	maxSize := int64(cfg.AccessLog.MaxSizeMB) * 1024 * 1024
+	file, err := accesslog.Open(cfg.AccessLog.Path, maxSize, cfg.AccessLog.MaxBackups)
+	if err != nil {
+		log.Printf("Access logging disabled: %v", err)
+		return nil
+	}
+	return file
+}
+
+// ReopenLogs reopens the access log file, e.g. after logrotate moved it
+func (s *Server) ReopenLogs() {
+	if s.accessLog == nil {
+		return
+	}
+	if err := s.accessLog.Reopen(); err != nil {
+		log.Printf("Error reopening access log: %v", err)
+	}
+}
+
+// newRequestID returns a random ID, or the one set by a trusted proxy
+func (s *Server) newRequestID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-ID"); id != "" && len(id) <= 64 && s.trustedRemote(r) {
+		return id
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// logRequests wraps the router and writes one JSON access log line per request
+func (s *Server) logRequests(router *mux.Router) http.Handler {
+	if s.accessLog == nil {
+		return router
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		entry := &accessEntry{
+			RequestID:      s.newRequestID(r),
+			ClientIP:       s.clientIP(r),
+			Method:         r.Method,
+			Path:           r.URL.Path,
+			RangeRequested: r.Header.Get("Range"),
+			UserAgent:      r.UserAgent(),
+		}
+
+		var match mux.RouteMatch
+		if router.Match(r, &match) {
+			entry.FileID = match.Vars["id"]
+			// A playlist token grants access to every file in it, so like the
+			// signature query of a signed link it stays out of the log
+			if token := match.Vars["token"]; token != "" {
+				entry.Path = strings.Replace(entry.Path, token, "<redacted>", 1)
+			}
+		}
+
+		w.Header().Set("X-Request-ID", entry.RequestID)
+		lw := &loggingResponseWriter{ResponseWriter: w}
+		router.ServeHTTP(lw, r.WithContext(context.WithValue(r.Context(), accessEntryKey{}, entry)))
+
+		if lw.status == 0 {
+			lw.status = http.StatusOK
+		}
+
+		entry.mu.Lock()
+		entry.Time = start.UTC().Format(time.RFC3339Nano)
+		entry.Status = lw.status
+		entry.Bytes = lw.bytes
+		entry.RangeServed = lw.rangeServed
+		entry.DurationMS = float64(time.Since(start).Microseconds()) / 1000
+		line, err := json.Marshal(entry)
+		entry.mu.Unlock()
+		if err != nil {
+			log.Printf("Error encoding access log entry: %v", err)
+			return
+		}
+
+		if _, err := s.accessLog.Write(append(line, '\n')); err != nil {
+			log.Printf("Error writing access log: %v", err)
+		}
+	})
+}
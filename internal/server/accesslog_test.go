@@ -0,0 +1,61 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"hafton-movie-bot/internal/config"
+
+	"github.com/gorilla/mux"
+)
+
+func TestAccessLogRedactsSecrets(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.AccessLog.Enabled = true
+	cfg.AccessLog.Path = filepath.Join(t.TempDir(), "access.log")
+	s := newTestServer(t, cfg)
+	defer s.accessLog.Close()
+
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	router := mux.NewRouter()
+	router.HandleFunc("/stream/{id}", ok)
+	router.HandleFunc("/playlist/{token:[A-Za-z0-9_-]+}.m3u8", ok)
+	handler := s.logRequests(router)
+
+	tests := []struct {
+		target string
+		path   string
+	}{
+		{"/stream/abc?exp=1700000000&sig=c2VjcmV0", "/stream/abc"},
+		{"/playlist/s3cr3t-Token_1.m3u8", "/playlist/<redacted>.m3u8"},
+	}
+	for _, tt := range tests {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tt.target, nil))
+	}
+
+	data, err := os.ReadFile(cfg.AccessLog.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != len(tests) {
+		t.Fatalf("logged %d lines, want %d", len(lines), len(tests))
+	}
+	for i, tt := range tests {
+		var entry accessEntry
+		if err := json.Unmarshal([]byte(lines[i]), &entry); err != nil {
+			t.Fatal(err)
+		}
+		if entry.Path != tt.path {
+			t.Errorf("%s: logged path %q, want %q", tt.target, entry.Path, tt.path)
+		}
+	}
+	if strings.Contains(string(data), "s3cr3t") || strings.Contains(string(data), "c2VjcmV0") {
+		t.Errorf("access log contains a secret:\n%s", data)
+	}
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"log"
+	"net/http"
+
+	"hafton-movie-bot/internal/config"
+	"hafton-movie-bot/internal/throttle"
+)
+
+// bandwidthLimits converts the configured KB/s caps into throttle limits
+func bandwidthLimits(cfg *config.Config) throttle.Limits {
+	bw := cfg.Bandwidth
+	limits := throttle.Limits{
+		Global:        int64(bw.GlobalKBps) * 1024,
+		PerConnection: int64(bw.PerConnectionKBps) * 1024,
+		PerFile:       int64(bw.PerFileKBps) * 1024,
+		DownloadShare: 1,
+	}
+	if bw.DownloadSharePercent > 0 {
+		limits.DownloadShare = float64(bw.DownloadSharePercent) / 100
+	}
+	return limits
+}
+
+// UpdateBandwidth applies the bandwidth section of cfg to all active and
+// future responses, without a restart
+func (s *Server) UpdateBandwidth(cfg *config.Config) {
+	limits := bandwidthLimits(cfg)
+	s.shaper.SetLimits(limits)
+	log.Printf("Bandwidth limits: global=%d B/s, per-connection=%d B/s, per-file=%d B/s, download share=%.0f%%",
+		limits.Global, limits.PerConnection, limits.PerFile, limits.DownloadShare*100)
+}
+
+// throttledResponseWriter sends the response body through the bandwidth shaper
+type throttledResponseWriter struct {
+	http.ResponseWriter
+	body *throttle.Writer
+}
+
+func (w *throttledResponseWriter) Write(p []byte) (int, error) {
+	return w.body.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *throttledResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// throttle wraps w with the bandwidth shaper for one response. The returned
+// function releases the connection's share and must be called when done.
+func (s *Server) throttle(w http.ResponseWriter, r *http.Request, fileID string, class throttle.Class) (http.ResponseWriter, func()) {
+	body, release := s.shaper.Writer(r.Context(), w, fileID, class)
+	return &throttledResponseWriter{ResponseWriter: w, body: body}, release
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+
+	"hafton-movie-bot/internal/database"
+	"hafton-movie-bot/internal/storage"
+)
+
+// cachedFile reads a proxied file in chunks. Missing chunks are fetched from
+// Telegram with a ranged request and kept in the disk cache and/or the shared
+// in-memory buffer for later and concurrent viewers. With parallel fetching
+// enabled, sequential reads load a window of chunks at once.
+type cachedFile struct {
+	s         *Server
+	ctx       context.Context
+	record    *database.FileRecord
+	cache     *storage.Cache // nil when only the shared buffer is in use
+	chunks    *sharedChunks  // nil unless coalescing is enabled
+	parallel  *parallelFile  // nil unless parallel fetching is enabled
+	chunkSize int64
+
+	// Called with Telegram's status code whenever a chunk is fetched
+	onUpstream func(status int)
+
+	// The last chunk used, since sequential reads hit it many times in a row
+	mu        sync.Mutex
+	lastIndex int64
+	lastData  []byte
+}
+
+// newCachedFile returns nil when neither the disk cache nor coalescing is
+// enabled, in which case proxied files are streamed straight through
+func (s *Server) newCachedFile(ctx context.Context, record *database.FileRecord) *cachedFile {
+	file := &cachedFile{s: s, ctx: ctx, record: record, cache: s.storage.Cache(), chunks: s.chunks, lastIndex: -1}
+	switch {
+	case file.cache != nil:
+		file.chunkSize = file.cache.ChunkSize()
+	case file.chunks != nil:
+		file.chunkSize = file.chunks.chunkSize
+	default:
+		return nil
+	}
+
+	if parallel := s.newParallelFile(ctx, record); parallel != nil {
+		parallel.chunkSize = file.chunkSize
+		parallel.load = file.load
+		file.parallel = parallel
+	}
+	return file
+}
+
+func (f *cachedFile) ReadAt(p []byte, off int64) (int, error) {
+	if f.parallel != nil {
+		return f.parallel.ReadAt(p, off)
+	}
+
+	size := f.record.FileSize
+	chunkSize := f.chunkSize
+	if off >= size {
+		return 0, io.EOF
+	}
+
+	n := 0
+	for n < len(p) && off+int64(n) < size {
+		pos := off + int64(n)
+		index := pos / chunkSize
+		data, err := f.chunk(index)
+		if err != nil {
+			return n, err
+		}
+		within := pos - index*chunkSize
+		if within >= int64(len(data)) {
+			return n, io.ErrUnexpectedEOF
+		}
+		n += copy(p[n:], data[within:])
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Close aborts parallel fetches still in flight. Chunks already fetched
+// outlive the request in the cache and shared buffer.
+func (f *cachedFile) Close() error {
+	if f.parallel != nil {
+		return f.parallel.Close()
+	}
+	return nil
+}
+
+// chunk returns one chunk, remembering it for the reads that follow
+func (f *cachedFile) chunk(index int64) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.lastIndex == index {
+		return f.lastData, nil
+	}
+
+	data, err := f.load(f.ctx, index)
+	if err != nil {
+		return nil, err
+	}
+
+	f.lastIndex = index
+	f.lastData = data
+	return data, nil
+}
+
+// load returns one chunk, sharing the fetch with concurrent readers of the
+// same file when coalescing is enabled. It gives up once ctx is done.
+func (f *cachedFile) load(ctx context.Context, index int64) ([]byte, error) {
+	if f.chunks != nil {
+		key := sharedChunkKey{fileID: f.record.ID, chunkSize: f.chunkSize, index: index}
+		return f.chunks.get(ctx, key, func(ctx context.Context) ([]byte, error) { return f.loadChunk(ctx, index) })
+	}
+	return f.loadChunk(ctx, index)
+}
+
+// loadChunk reads a chunk from the disk cache, filling it from upstream if needed
+func (f *cachedFile) loadChunk(ctx context.Context, index int64) ([]byte, error) {
+	if f.cache != nil {
+		if data, ok := f.cache.ReadChunk(f.record.ID, index); ok {
+			return data, nil
+		}
+	}
+
+	data, err := f.fetchChunk(ctx, index)
+	if err != nil {
+		return nil, err
+	}
+	if f.cache != nil {
+		if err := f.cache.WriteChunk(f.record.ID, index, data); err != nil {
+			log.Printf("Error caching chunk %s/%d: %v", f.record.ID, index, err)
+		}
+	}
+	return data, nil
+}
+
+// fetchChunk downloads one chunk, retrying with backoff if Telegram fails
+// or drops the connection part way
+func (f *cachedFile) fetchChunk(ctx context.Context, index int64) ([]byte, error) {
+	retry := f.s.newUpstreamRetry()
+	for {
+		data, err := f.fetchChunkOnce(ctx, index)
+		if err == nil {
+			return data, nil
+		}
+		if !retry.wait(ctx) {
+			return nil, err
+		}
+		log.Printf("Error fetching chunk %s/%d: %v, retrying (attempt %d/%d)", f.record.ID, index, err, retry.attempt(), retry.max)
+	}
+}
+
+func (f *cachedFile) fetchChunkOnce(ctx context.Context, index int64) ([]byte, error) {
+	chunkSize := f.chunkSize
+	start := index * chunkSize
+	end := min(start+chunkSize, f.record.FileSize) - 1
+
+	resp, err := f.s.fetchUpstream(ctx, f.record, http.MethodGet, fmt.Sprintf("bytes=%d-%d", start, end))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if f.onUpstream != nil {
+		f.onUpstream(resp.StatusCode)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// Upstream ignored the range - skip ahead to the chunk
+		if _, err := io.CopyN(io.Discard, resp.Body, start); err != nil {
+			return nil, fmt.Errorf("failed to skip to chunk %d: %w", index, err)
+		}
+	default:
+		return nil, fmt.Errorf("telegram returned status %d for chunk %d", resp.StatusCode, index)
+	}
+
+	data := make([]byte, end-start+1)
+	if _, err := io.ReadFull(resp.Body, data); err != nil {
+		return nil, fmt.Errorf("failed to read chunk %d: %w", index, err)
+	}
+	return data, nil
+}
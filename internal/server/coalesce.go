@@ -0,0 +1,155 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"sync"
+
+	"hafton-movie-bot/internal/config"
+	"hafton-movie-bot/internal/metrics"
+)
+
+// defaultCoalesceChunkSize and defaultCoalesceBuffer apply when the coalesce
+// section leaves them unset
+const (
+	defaultCoalesceChunkSize = 1024 * 1024
+	defaultCoalesceBuffer    = 64 * 1024 * 1024
+)
+
+// sharedChunks lets concurrent viewers of a proxied file share upstream
+// fetches. A chunk requested while it is already being fetched waits for that
+// fetch instead of starting another, and recently fetched chunks are kept in
+// a small LRU buffer, so viewers watching at about the same position cost
+// roughly one upstream stream.
+type sharedChunks struct {
+	chunkSize int64
+	maxSize   int64
+
+	mu       sync.Mutex
+	inflight map[sharedChunkKey]*chunkCall
+	entries  map[sharedChunkKey]*list.Element
+	lru      *list.List // of *sharedChunk, most recently used first
+	size     int64
+}
+
+type sharedChunkKey struct {
+	fileID    string
+	chunkSize int64
+	index     int64
+}
+
+type sharedChunk struct {
+	key  sharedChunkKey
+	data []byte
+}
+
+// chunkCall is a fetch in progress; done is closed once data or err is set.
+// The fetch is cancelled when every reader waiting for it has given up.
+type chunkCall struct {
+	done    chan struct{}
+	cancel  context.CancelFunc
+	waiters int // Guarded by sharedChunks.mu
+	data    []byte
+	err     error
+}
+
+// newSharedChunks returns nil unless coalescing is enabled
+func newSharedChunks(cfg *config.Config) *sharedChunks {
+	if !cfg.Coalesce.Enabled {
+		return nil
+	}
+
+	chunkSize := int64(cfg.Coalesce.ChunkSizeKB) * 1024
+	if chunkSize <= 0 {
+		chunkSize = defaultCoalesceChunkSize
+	}
+	maxSize := int64(cfg.Coalesce.BufferMB) * 1024 * 1024
+	if maxSize <= 0 {
+		maxSize = defaultCoalesceBuffer
+	}
+	return &sharedChunks{
+		chunkSize: chunkSize,
+		maxSize:   maxSize,
+		inflight:  make(map[sharedChunkKey]*chunkCall),
+		entries:   make(map[sharedChunkKey]*list.Element),
+		lru:       list.New(),
+	}
+}
+
+// get returns a chunk from the buffer, joins a fetch of it already in flight
+// or starts fetch and shares the result with everyone who asked meanwhile.
+// The fetch doesn't belong to any one reader: it runs until it completes or
+// the last reader waiting for it gives up, and get returns once ctx is done.
+func (c *sharedChunks) get(ctx context.Context, key sharedChunkKey, fetch func(ctx context.Context) ([]byte, error)) ([]byte, error) {
+	c.mu.Lock()
+	if elem, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(elem)
+		c.mu.Unlock()
+		metrics.SharedChunks.Inc("buffered")
+		return elem.Value.(*sharedChunk).data, nil
+	}
+	call, ok := c.inflight[key]
+	if ok {
+		metrics.SharedChunks.Inc("joined")
+	} else {
+		fetchCtx, cancel := context.WithCancel(context.Background())
+		call = &chunkCall{done: make(chan struct{}), cancel: cancel}
+		c.inflight[key] = call
+		metrics.SharedChunks.Inc("fetched")
+		go c.run(fetchCtx, key, call, fetch)
+	}
+	call.waiters++
+	c.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.data, call.err
+	case <-ctx.Done():
+	}
+
+	c.mu.Lock()
+	call.waiters--
+	if call.waiters == 0 {
+		call.cancel()
+		// Readers arriving later start a fetch of their own
+		if c.inflight[key] == call {
+			delete(c.inflight, key)
+		}
+	}
+	c.mu.Unlock()
+	return nil, ctx.Err()
+}
+
+// run performs a shared fetch and hands the result to its waiters
+func (c *sharedChunks) run(ctx context.Context, key sharedChunkKey, call *chunkCall, fetch func(ctx context.Context) ([]byte, error)) {
+	data, err := fetch(ctx)
+	call.cancel()
+
+	c.mu.Lock()
+	if c.inflight[key] == call {
+		delete(c.inflight, key)
+	}
+	call.data, call.err = data, err
+	// An abandoned fetch may finish after a newer one buffered the chunk
+	if _, buffered := c.entries[key]; err == nil && !buffered {
+		c.addLocked(key, data)
+	}
+	c.mu.Unlock()
+	close(call.done)
+}
+
+func (c *sharedChunks) addLocked(key sharedChunkKey, data []byte) {
+	if int64(len(data)) > c.maxSize {
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&sharedChunk{key: key, data: data})
+	c.size += int64(len(data))
+
+	for c.size > c.maxSize {
+		oldest := c.lru.Back()
+		chunk := oldest.Value.(*sharedChunk)
+		c.lru.Remove(oldest)
+		delete(c.entries, chunk.key)
+		c.size -= int64(len(chunk.data))
+	}
+}
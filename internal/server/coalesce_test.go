@@ -0,0 +1,207 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"hafton-movie-bot/internal/config"
+)
+
+func newTestSharedChunks(chunkSizeKB, bufferMB int) *sharedChunks {
+	cfg := &config.Config{}
+	cfg.Coalesce.Enabled = true
+	cfg.Coalesce.ChunkSizeKB = chunkSizeKB
+	cfg.Coalesce.BufferMB = bufferMB
+	return newSharedChunks(cfg)
+}
+
+func TestSharedChunksJoinFetchInFlight(t *testing.T) {
+	c := newTestSharedChunks(1, 1)
+	key := sharedChunkKey{fileID: "abc", chunkSize: 1024, index: 3}
+
+	var fetches atomic.Int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	fetch := func(ctx context.Context) ([]byte, error) {
+		if fetches.Add(1) == 1 {
+			close(started)
+		}
+		<-release
+		return []byte("chunk"), nil
+	}
+
+	results := make(chan []byte, 5)
+	go func() {
+		data, _ := c.get(context.Background(), key, fetch)
+		results <- data
+	}()
+	<-started
+	for i := 0; i < 4; i++ {
+		go func() {
+			data, _ := c.get(context.Background(), key, fetch)
+			results <- data
+		}()
+	}
+	// Let the other readers reach the fetch in flight
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	for i := 0; i < 5; i++ {
+		if data := <-results; string(data) != "chunk" {
+			t.Errorf("reader got %q", data)
+		}
+	}
+	if got, _ := c.get(context.Background(), key, fetch); string(got) != "chunk" {
+		t.Errorf("buffered chunk = %q", got)
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("fetched %d times, want 1", n)
+	}
+}
+
+func TestSharedChunksReadersCanLeave(t *testing.T) {
+	c := newTestSharedChunks(1, 1)
+	key := sharedChunkKey{fileID: "abc", chunkSize: 1024}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	cancelled := make(chan struct{})
+	fetch := func(ctx context.Context) ([]byte, error) {
+		close(started)
+		select {
+		case <-release:
+			return []byte("chunk"), nil
+		case <-ctx.Done():
+			close(cancelled)
+			return nil, ctx.Err()
+		}
+	}
+
+	// A reader giving up doesn't stop the fetch for the others
+	leaving, leave := context.WithCancel(context.Background())
+	left := make(chan error)
+	go func() {
+		_, err := c.get(leaving, key, fetch)
+		left <- err
+	}()
+	<-started
+	staying := make(chan []byte)
+	go func() {
+		data, _ := c.get(context.Background(), key, fetch)
+		staying <- data
+	}()
+	time.Sleep(10 * time.Millisecond)
+	leave()
+	if err := <-left; !errors.Is(err, context.Canceled) {
+		t.Errorf("leaving reader got %v, want %v", err, context.Canceled)
+	}
+	close(release)
+	if data := <-staying; string(data) != "chunk" {
+		t.Errorf("remaining reader got %q", data)
+	}
+
+	// Once the last reader leaves the fetch is called off
+	key.index = 1
+	started = make(chan struct{})
+	release = make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	if _, err := c.get(ctx, key, fetch); !errors.Is(err, context.Canceled) {
+		t.Errorf("get() error = %v, want %v", err, context.Canceled)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("abandoned fetch kept running")
+	}
+	data, err := c.get(context.Background(), key, func(ctx context.Context) ([]byte, error) { return []byte("again"), nil })
+	if err != nil || string(data) != "again" {
+		t.Errorf("get() after the fetch was abandoned = %q, %v", data, err)
+	}
+}
+
+func TestSharedChunksErrorsAreNotBuffered(t *testing.T) {
+	c := newTestSharedChunks(1, 1)
+	key := sharedChunkKey{fileID: "abc", chunkSize: 1024}
+
+	failure := errors.New("upstream broke")
+	if _, err := c.get(context.Background(), key, func(ctx context.Context) ([]byte, error) { return nil, failure }); !errors.Is(err, failure) {
+		t.Fatalf("get() error = %v, want %v", err, failure)
+	}
+	data, err := c.get(context.Background(), key, func(ctx context.Context) ([]byte, error) { return []byte("retried"), nil })
+	if err != nil || string(data) != "retried" {
+		t.Errorf("get() after an error = %q, %v", data, err)
+	}
+}
+
+func TestSharedChunksEvictLeastRecentlyUsed(t *testing.T) {
+	c := newTestSharedChunks(512, 1)
+	chunk := make([]byte, 512*1024)
+	keys := []sharedChunkKey{{"abc", 512 * 1024, 0}, {"abc", 512 * 1024, 1}, {"abc", 512 * 1024, 2}}
+
+	var fetches int
+	fetch := func(ctx context.Context) ([]byte, error) {
+		fetches++
+		return chunk, nil
+	}
+	c.get(context.Background(), keys[0], fetch)
+	c.get(context.Background(), keys[1], fetch)
+	c.get(context.Background(), keys[0], fetch)
+	c.get(context.Background(), keys[2], fetch) // Evicts chunk 1
+
+	if fetches != 3 || c.size != 1024*1024 {
+		t.Fatalf("%d fetches with %d bytes buffered, want 3 and 1 MiB", fetches, c.size)
+	}
+	c.get(context.Background(), keys[0], fetch)
+	c.get(context.Background(), keys[1], fetch)
+	if fetches != 4 {
+		t.Errorf("fetched %d times, want chunk 1 fetched again only", fetches)
+	}
+}
+
+func TestCoalescedViewers(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Coalesce.Enabled = true
+	cfg.Coalesce.ChunkSizeKB = 16
+	cfg.Coalesce.BufferMB = 1
+	s := newTestServer(t, cfg)
+	content := testContent(100000)
+	upstream := newTestUpstream(t, content)
+	addProxiedFile(t, s, "abc", upstream.fileURL(), int64(len(content)))
+
+	const viewers = 5
+	var wg sync.WaitGroup
+	bodies := make([][]byte, viewers)
+	for i := 0; i < viewers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rec := serve(s.handleStream, fileRequest(http.MethodGet, "/stream/abc", fmt.Sprintf("10.0.0.%d", i+1), "player"), "abc")
+			if rec.Code != http.StatusOK {
+				t.Errorf("viewer %d: status = %d", i, rec.Code)
+			}
+			bodies[i] = rec.Body.Bytes()
+		}(i)
+	}
+	wg.Wait()
+
+	for i, body := range bodies {
+		if !bytes.Equal(body, content) {
+			t.Errorf("viewer %d got %d bytes, want the %d byte file", i, len(body), len(content))
+		}
+	}
+	// One request per 16 KiB chunk, however many viewers
+	if got := len(upstream.requests()); got != 7 {
+		t.Errorf("upstream got %d requests, want 7", got)
+	}
+}
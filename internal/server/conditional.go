@@ -0,0 +1,94 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"hafton-movie-bot/internal/database"
+)
+
+// fileETag returns a strong validator for the record. Records are immutable once
+// inserted, so the ID plus size is enough to identify the representation.
+func fileETag(record *database.FileRecord) string {
+	return fmt.Sprintf(`"%s-%x"`, record.ID, record.FileSize)
+}
+
+// faststartETag identifies the virtual faststart layout of a record, which
+// differs byte for byte from the uploaded file
+func faststartETag(record *database.FileRecord, size int64) string {
+	return fmt.Sprintf(`"%s-%x-faststart"`, record.ID, size)
+}
+
+// setValidators adds the ETag and Last-Modified headers. A zero modified time
+// leaves out Last-Modified, so only the ETag can validate the representation.
+func setValidators(w http.ResponseWriter, etag string, modified time.Time) {
+	w.Header().Set("ETag", etag)
+	if !modified.IsZero() {
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+}
+
+// checkNotModified evaluates If-None-Match and If-Modified-Since (RFC 7232 section 6).
+// It writes a 304 and returns true when the client copy is still fresh.
+func checkNotModified(w http.ResponseWriter, r *http.Request, etag string, modified time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if !etagListMatches(inm, etag) {
+			return false
+		}
+	} else if ims := r.Header.Get("If-Modified-Since"); ims != "" && !modified.IsZero() {
+		t, err := http.ParseTime(ims)
+		if err != nil || modified.Truncate(time.Second).After(t) {
+			return false
+		}
+	} else {
+		return false
+	}
+
+	// 304 responses must not carry representation headers
+	h := w.Header()
+	delete(h, "Content-Type")
+	delete(h, "Content-Length")
+	delete(h, "Content-Disposition")
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// rangeAllowed reports whether the Range header should be honoured. A Range
+// request with an If-Range validator that no longer matches gets the full file.
+// Without a modified time only the entity tag form can match.
+func rangeAllowed(r *http.Request, etag string, modified time.Time) bool {
+	ir := r.Header.Get("If-Range")
+	if ir == "" {
+		return true
+	}
+
+	// Entity tag form - If-Range requires a strong comparison
+	if strings.HasPrefix(ir, `"`) || strings.HasPrefix(ir, "W/") {
+		return ir == etag
+	}
+
+	// HTTP-date form
+	t, err := http.ParseTime(ir)
+	if err != nil || modified.IsZero() {
+		return false
+	}
+	return modified.Truncate(time.Second).Equal(t)
+}
+
+// etagListMatches checks a comma separated If-None-Match list against etag
+// using the weak comparison, which ignores the W/ prefix on either side
+func etagListMatches(list, etag string) bool {
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"hafton-movie-bot/internal/config"
+)
+
+// A client's slot is held this long after its last request finishes, so a
+// player issuing sequential range requests keeps its place
+const connectionGrace = 10 * time.Second
+
+// connLimiter caps simultaneous streams per file, per client IP and in total.
+// A "connection" is one client (IP + user agent) reading one file: parallel or
+// back-to-back range requests from the same player share a single slot, but
+// only up to perClientMax of them may be open at once, so download managers
+// can't split a file over unlimited parallel ranges.
+type connLimiter struct {
+	perFileMax   int
+	perIPMax     int
+	totalMax     int
+	perClientMax int
+
+	mu       sync.Mutex
+	sessions map[string]*connSession
+	perFile  map[string]int
+	perIP    map[string]int
+	total    int
+}
+
+type connSession struct {
+	fileID    string
+	ip        string
+	active    int
+	idleSince time.Time
+}
+
+func newConnLimiter(cfg *config.Config) *connLimiter {
+	return &connLimiter{
+		perFileMax:   cfg.Connections.PerFile,
+		perIPMax:     cfg.Connections.PerIP,
+		totalMax:     cfg.Connections.Total,
+		perClientMax: cfg.Connections.PerClient,
+		sessions:     make(map[string]*connSession),
+		perFile:      make(map[string]int),
+		perIP:        make(map[string]int),
+	}
+}
+
+// acquire claims a slot for the request. It returns false if a limit is hit;
+// otherwise release must be called when the response is done.
+func (l *connLimiter) acquire(fileID, ip, userAgent string) (func(), bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.expireLocked(now)
+
+	key := fileID + "|" + ip + "|" + userAgent
+	session, ok := l.sessions[key]
+	if !ok {
+		if exceeds(l.perFile[fileID], l.perFileMax) || exceeds(l.perIP[ip], l.perIPMax) || exceeds(l.total, l.totalMax) {
+			return nil, false
+		}
+		session = &connSession{fileID: fileID, ip: ip}
+		l.sessions[key] = session
+		l.perFile[fileID]++
+		l.perIP[ip]++
+		l.total++
+	} else if exceeds(session.active, l.perClientMax) {
+		return nil, false
+	}
+	session.active++
+
+	release := func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		session.active--
+		if session.active == 0 {
+			session.idleSince = time.Now()
+		}
+	}
+	return release, true
+}
+
+func exceeds(count, max int) bool {
+	return max > 0 && count >= max
+}
+
+// expireLocked frees the slots of clients idle for longer than the grace period
+func (l *connLimiter) expireLocked(now time.Time) {
+	for key, session := range l.sessions {
+		if session.active > 0 || now.Sub(session.idleSince) < connectionGrace {
+			continue
+		}
+		delete(l.sessions, key)
+		if l.perFile[session.fileID]--; l.perFile[session.fileID] == 0 {
+			delete(l.perFile, session.fileID)
+		}
+		if l.perIP[session.ip]--; l.perIP[session.ip] == 0 {
+			delete(l.perIP, session.ip)
+		}
+		l.total--
+	}
+}
+
+// checkConnections enforces the concurrent connection limits. HEAD probes
+// aren't counted. On success the returned function must be called when the
+// response is done; otherwise a 429 has been written.
+func (s *Server) checkConnections(w http.ResponseWriter, r *http.Request, fileID string) (func(), bool) {
+	if r.Method == http.MethodHead {
+		return func() {}, true
+	}
+
+	release, ok := s.connections.acquire(fileID, s.clientIP(r), r.UserAgent())
+	if !ok {
+		log.Printf("Too many connections for %s from %s", fileID, s.clientIP(r))
+		w.Header().Set("Retry-After", strconv.Itoa(int(connectionGrace.Seconds())))
+		s.renderTemplate(w, http.StatusTooManyRequests, "message.html", messagePage{
+			Icon:    "⏳",
+			Title:   "Too Many Viewers",
+			Message: "This file or your connection has reached the maximum number of simultaneous streams. Please try again in a few seconds.",
+		})
+		return nil, false
+	}
+	return release, true
+}
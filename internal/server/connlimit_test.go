@@ -0,0 +1,182 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"hafton-movie-bot/internal/config"
+)
+
+func TestConnLimiter(t *testing.T) {
+	type acquire struct {
+		fileID, ip, ua string
+		want           bool
+	}
+	tests := []struct {
+		name     string
+		limits   config.Config
+		acquires []acquire
+	}{
+		{
+			name: "per file",
+			acquires: []acquire{
+				{"a", "10.0.0.1", "vlc", true},
+				{"a", "10.0.0.1", "vlc", true},
+				{"a", "10.0.0.2", "vlc", false},
+				{"b", "10.0.0.2", "vlc", true},
+			},
+		},
+		{
+			name: "per ip",
+			acquires: []acquire{
+				{"a", "10.0.0.1", "vlc", true},
+				{"b", "10.0.0.1", "vlc", false},
+				{"a", "10.0.0.1", "mpv", false},
+				{"b", "10.0.0.2", "vlc", true},
+			},
+		},
+		{
+			name: "total",
+			acquires: []acquire{
+				{"a", "10.0.0.1", "vlc", true},
+				{"b", "10.0.0.2", "vlc", true},
+				{"c", "10.0.0.3", "vlc", false},
+				{"b", "10.0.0.2", "vlc", true},
+			},
+		},
+		{
+			name: "unlimited",
+			acquires: []acquire{
+				{"a", "10.0.0.1", "vlc", true},
+				{"a", "10.0.0.1", "mpv", true},
+				{"b", "10.0.0.1", "vlc", true},
+				{"a", "10.0.0.1", "vlc", true},
+				{"a", "10.0.0.1", "vlc", true},
+				{"a", "10.0.0.1", "vlc", true},
+				{"a", "10.0.0.1", "vlc", true},
+				{"a", "10.0.0.1", "vlc", true},
+			},
+		},
+	}
+	tests[0].limits.Connections.PerFile = 1
+	tests[1].limits.Connections.PerIP = 1
+	tests[2].limits.Connections.Total = 2
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newConnLimiter(&tt.limits)
+			for i, a := range tt.acquires {
+				if _, ok := l.acquire(a.fileID, a.ip, a.ua); ok != a.want {
+					t.Errorf("acquire %d (%s from %s %s) = %v, want %v", i, a.fileID, a.ip, a.ua, ok, a.want)
+				}
+			}
+		})
+	}
+}
+
+func TestConnLimiterGracePeriod(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Connections.PerFile = 1
+	l := newConnLimiter(cfg)
+
+	release, ok := l.acquire("a", "10.0.0.1", "vlc")
+	if !ok {
+		t.Fatal("first acquire failed")
+	}
+	release()
+
+	// The slot stays reserved for the player's next range request
+	if _, ok := l.acquire("a", "10.0.0.2", "vlc"); ok {
+		t.Error("slot was given away within the grace period")
+	}
+
+	for _, session := range l.sessions {
+		session.idleSince = time.Now().Add(-connectionGrace)
+	}
+	release, ok = l.acquire("a", "10.0.0.2", "vlc")
+	if !ok {
+		t.Fatal("slot wasn't freed after the grace period")
+	}
+	if l.total != 1 || len(l.perIP) != 1 {
+		t.Errorf("total = %d with %d client IPs after expiry, want 1 and 1", l.total, len(l.perIP))
+	}
+	release()
+}
+
+func TestTooManyConnections(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Connections.PerIP = 1
+	s := newTestServer(t, cfg)
+	addTestFile(t, s, "abc", []byte("0123456789"))
+	addTestFile(t, s, "def", []byte("0123456789"))
+
+	steps := []struct {
+		name   string
+		method string
+		id     string
+		ip     string
+		want   int
+	}{
+		{"first stream", http.MethodGet, "abc", "10.0.0.1", http.StatusOK},
+		{"same player again", http.MethodGet, "abc", "10.0.0.1", http.StatusOK},
+		{"another file from the same client", http.MethodGet, "def", "10.0.0.1", http.StatusTooManyRequests},
+		{"probe from the same client", http.MethodHead, "def", "10.0.0.1", http.StatusOK},
+		{"another client", http.MethodGet, "def", "10.0.0.2", http.StatusOK},
+	}
+	for _, step := range steps {
+		rec := serve(s.handleStream, fileRequest(step.method, "/stream/"+step.id, step.ip, "player"), step.id)
+		if rec.Code != step.want {
+			t.Errorf("%s: status = %d, want %d", step.name, rec.Code, step.want)
+		}
+		if step.want == http.StatusTooManyRequests && rec.Header().Get("Retry-After") != "10" {
+			t.Errorf("%s: Retry-After = %q, want %q", step.name, rec.Header().Get("Retry-After"), "10")
+		}
+	}
+}
+
+func TestConnLimiterPerClient(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Connections.PerClient = 2
+	l := newConnLimiter(cfg)
+
+	first, ok1 := l.acquire("a", "10.0.0.1", "aria2")
+	_, ok2 := l.acquire("a", "10.0.0.1", "aria2")
+	_, ok3 := l.acquire("a", "10.0.0.1", "aria2")
+	if !ok1 || !ok2 || ok3 {
+		t.Fatalf("acquires = %v, %v, %v, want true, true, false", ok1, ok2, ok3)
+	}
+	// Other players and files aren't affected
+	if _, ok := l.acquire("a", "10.0.0.1", "vlc"); !ok {
+		t.Error("another player from the same IP was refused")
+	}
+	if _, ok := l.acquire("b", "10.0.0.1", "aria2"); !ok {
+		t.Error("another file for the same client was refused")
+	}
+
+	first()
+	if _, ok := l.acquire("a", "10.0.0.1", "aria2"); !ok {
+		t.Error("a released request's place wasn't given back")
+	}
+}
+
+func TestTooManyParallelRanges(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Connections.PerClient = 1
+	s := newTestServer(t, cfg)
+	addTestFile(t, s, "abc", []byte("0123456789"))
+
+	// Hold one request open as a download manager would
+	release, ok := s.connections.acquire("abc", "10.0.0.1", "aria2")
+	if !ok {
+		t.Fatal("first acquire failed")
+	}
+	rec := serve(s.handleDownload, fileRequest(http.MethodGet, "/file/abc", "10.0.0.1", "aria2"), "abc")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("second parallel range: status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	release()
+	if rec := serve(s.handleDownload, fileRequest(http.MethodGet, "/file/abc", "10.0.0.1", "aria2"), "abc"); rec.Code != http.StatusOK {
+		t.Errorf("after the first finished: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"io"
+	"log"
+	"sync"
+
+	"hafton-movie-bot/internal/database"
+	"hafton-movie-bot/internal/mediainfo"
+)
+
+// maxFaststartLayouts bounds how many rewritten moov boxes are kept in memory
+const maxFaststartLayouts = 64
+
+// faststartCache remembers each file's faststart layout, including files that
+// don't need one, so the box structure is only walked once
+type faststartCache struct {
+	mu      sync.Mutex
+	layouts map[string]*mediainfo.FaststartLayout
+	order   []string
+}
+
+func newFaststartCache() *faststartCache {
+	return &faststartCache{layouts: make(map[string]*mediainfo.FaststartLayout)}
+}
+
+// faststartLayout returns the virtual faststart layout for an MP4 whose moov
+// box follows its media data, or nil to serve the file as it is
+func (s *Server) faststartLayout(record *database.FileRecord, content io.ReaderAt, size int64) *mediainfo.FaststartLayout {
+	if !s.config.Server.Faststart || !mediainfo.Probeable(record.FileName, record.FileType) {
+		return nil
+	}
+
+	c := s.faststart
+	c.mu.Lock()
+	layout, ok := c.layouts[record.ID]
+	c.mu.Unlock()
+	if ok {
+		return layout
+	}
+	if !s.faststartReadable(record) {
+		return nil
+	}
+
+	layout, err := mediainfo.NewFaststartLayout(content, size)
+	if err != nil {
+		log.Printf("Error building faststart layout for %s: %v", record.ID, err)
+		return nil
+	}
+	if layout != nil {
+		log.Printf("Serving %s with moov relocated to the front", record.ID)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.layouts[record.ID]; !ok {
+		if len(c.order) >= maxFaststartLayouts {
+			delete(c.layouts, c.order[0])
+			c.order = c.order[1:]
+		}
+		c.layouts[record.ID] = layout
+		c.order = append(c.order, record.ID)
+	}
+	return layout
+}
+
+// faststartReadable reports whether a file's boxes can be walked without
+// waiting on Telegram. The walk reads box headers spread over the whole file
+// and then the moov box, which for a proxied file means a round trip each,
+// so proxied files are only rewritten once they are wholly in the disk cache.
+func (s *Server) faststartReadable(record *database.FileRecord) bool {
+	switch record.SourceKind {
+	case database.SourceStorage, database.SourceBotAPI:
+		return true
+	case database.SourceTelegram:
+		cache := s.storage.Cache()
+		if cache == nil || record.FileSize <= 0 {
+			return false
+		}
+		chunks := (record.FileSize + cache.ChunkSize() - 1) / cache.ChunkSize()
+		for index := int64(0); index < chunks; index++ {
+			if !cache.Has(record.ID, index) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"hafton-movie-bot/internal/config"
+	"hafton-movie-bot/internal/database"
+)
+
+// testBox builds an MP4 box
+func testBox(kind string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	out := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(out, kind...), body...)
+}
+
+// moovAtEndMP4 returns an MP4 whose moov box follows its media data
+func moovAtEndMP4() []byte {
+	ftyp := testBox("ftyp", []byte("isom\x00\x00\x02\x00isomiso2"))
+	mdat := testBox("mdat", testContent(4096))
+	stco := binary.BigEndian.AppendUint32(make([]byte, 4), 1)
+	stco = binary.BigEndian.AppendUint32(stco, uint32(len(ftyp)+8))
+	moov := testBox("moov", testBox("trak", testBox("mdia", testBox("minf", testBox("stbl", testBox("stco", stco))))))
+	return bytes.Join([][]byte{ftyp, mdat, moov}, nil)
+}
+
+func TestFaststartLayoutSources(t *testing.T) {
+	data := moovAtEndMP4()
+
+	tests := []struct {
+		name   string
+		kind   string
+		cached int64 // Leading bytes of the file in the disk cache; -1 disables it
+		want   bool
+	}{
+		{"stored file", database.SourceStorage, -1, true},
+		{"local Bot API file", database.SourceBotAPI, -1, true},
+		{"proxied file without a cache", database.SourceTelegram, -1, false},
+		{"proxied file partly cached", database.SourceTelegram, 2048, false},
+		{"proxied file wholly cached", database.SourceTelegram, int64(len(data)), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.Server.Faststart = true
+			s := newTestServer(t, cfg)
+			record := &database.FileRecord{
+				ID:         "abc",
+				SourceKind: tt.kind,
+				FileName:   "abc.mp4",
+				FileType:   "video/mp4",
+				FileSize:   int64(len(data)),
+			}
+			if tt.cached >= 0 {
+				const chunkSize = 1024
+				if err := s.storage.EnableCache(chunkSize, 1<<20); err != nil {
+					t.Fatal(err)
+				}
+				for off := int64(0); off < tt.cached; off += chunkSize {
+					chunk := data[off:min(off+chunkSize, int64(len(data)))]
+					if err := s.storage.Cache().WriteChunk(record.ID, off/chunkSize, chunk); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			layout := s.faststartLayout(record, bytes.NewReader(data), int64(len(data)))
+			if got := layout != nil; got != tt.want {
+				t.Errorf("rewritten = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
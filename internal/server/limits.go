@@ -0,0 +1,169 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"hafton-movie-bot/internal/database"
+)
+
+// A playback or download session ends after this long without requests.
+// Players issue many range requests per session; only the first one counts.
+const sessionIdleTimeout = 30 * time.Minute
+
+const (
+	sessionStream   = "stream"
+	sessionDownload = "download"
+)
+
+// sessionTracker remembers which clients already have an active session for
+// a file, so follow-up range requests aren't counted again
+type sessionTracker struct {
+	mu        sync.Mutex
+	sessions  map[string]*session
+	lastPrune time.Time
+}
+
+type session struct {
+	lastSeen time.Time
+	claiming chan struct{} // Closed once the first request settles its claim, nil after
+}
+
+func newSessionTracker() *sessionTracker {
+	return &sessionTracker{sessions: make(map[string]*session)}
+}
+
+// active reports whether key had a request within the idle timeout or is
+// being claimed right now
+func (t *sessionTracker) active(key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	session, ok := t.sessions[key]
+	return ok && (session.claiming != nil || time.Since(session.lastSeen) < sessionIdleTimeout)
+}
+
+// claim touches key's session and returns true if it is active. Otherwise
+// the caller becomes the session's first request and must call settle with
+// whether it was allowed to start; concurrent requests for the same key wait
+// for that instead of starting sessions of their own.
+func (t *sessionTracker) claim(ctx context.Context, key string) (bool, func(started bool), error) {
+	for {
+		t.mu.Lock()
+		existing, ok := t.sessions[key]
+		if ok && existing.claiming != nil {
+			wait := existing.claiming
+			t.mu.Unlock()
+			select {
+			case <-wait:
+				continue
+			case <-ctx.Done():
+				return false, nil, ctx.Err()
+			}
+		}
+
+		now := time.Now()
+		if ok && now.Sub(existing.lastSeen) < sessionIdleTimeout {
+			existing.lastSeen = now
+			t.mu.Unlock()
+			return true, nil, nil
+		}
+
+		claimed := &session{claiming: make(chan struct{})}
+		t.sessions[key] = claimed
+		t.pruneLocked(now)
+		t.mu.Unlock()
+
+		settle := func(started bool) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if started {
+				claimed.lastSeen = time.Now()
+			} else if t.sessions[key] == claimed {
+				delete(t.sessions, key)
+			}
+			close(claimed.claiming)
+			claimed.claiming = nil
+		}
+		return false, settle, nil
+	}
+}
+
+// pruneLocked drops idle sessions now and then
+func (t *sessionTracker) pruneLocked(now time.Time) {
+	if now.Sub(t.lastPrune) <= sessionIdleTimeout {
+		return
+	}
+	for key, session := range t.sessions {
+		if session.claiming == nil && now.Sub(session.lastSeen) >= sessionIdleTimeout {
+			delete(t.sessions, key)
+		}
+	}
+	t.lastPrune = now
+}
+
+// sessionKey identifies one client's session for a file on an endpoint
+func (s *Server) sessionKey(r *http.Request, record *database.FileRecord, kind string) string {
+	return kind + "|" + record.ID + "|" + s.clientIP(r) + "|" + r.UserAgent()
+}
+
+// limitReached reports whether the cap for kind is used up
+func limitReached(record *database.FileRecord, kind string) bool {
+	if kind == sessionDownload {
+		return record.MaxDownloads > 0 && record.DownloadCount >= record.MaxDownloads
+	}
+	return record.MaxStreams > 0 && record.StreamCount >= record.MaxStreams
+}
+
+// checkLimit counts a new session against the file's cap. Requests belonging
+// to a session that was already counted always pass. It shows the exhausted
+// page and returns false once the cap has been reached.
+func (s *Server) checkLimit(w http.ResponseWriter, r *http.Request, record *database.FileRecord, kind string) bool {
+	key := s.sessionKey(r, record, kind)
+
+	// Probes don't start a session
+	if r.Method == http.MethodHead {
+		if s.sessions.active(key) || !limitReached(record, kind) {
+			return true
+		}
+		s.serveExhaustedPage(w, r)
+		return false
+	}
+
+	active, settle, err := s.sessions.claim(r.Context(), key)
+	if err != nil {
+		// The client went away while another request was starting the session
+		return false
+	}
+	if active {
+		return true
+	}
+
+	consume := s.db.ConsumeStream
+	if kind == sessionDownload {
+		consume = s.db.ConsumeDownload
+	}
+	ok, err := consume(record.ID)
+	settle(err == nil && ok)
+	if err != nil {
+		log.Printf("Error counting %s for %s: %v", kind, record.ID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return false
+	}
+	if !ok {
+		log.Printf("File %s reached its %s limit", record.ID, kind)
+		s.serveExhaustedPage(w, r)
+		return false
+	}
+	return true
+}
+
+func (s *Server) serveExhaustedPage(w http.ResponseWriter, r *http.Request) {
+	s.renderTemplate(w, http.StatusGone, "message.html", messagePage{
+		Icon:    "🚫",
+		Title:   "Link Used Up",
+		Message: "This link has reached its maximum number of views or downloads. Ask the sender for a new link.",
+	})
+}
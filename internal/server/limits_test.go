@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"hafton-movie-bot/internal/config"
+)
+
+// fileRequest builds a request for a file endpoint from a given client
+func fileRequest(method, path, ip, userAgent string) *http.Request {
+	req := httptest.NewRequest(method, path, nil)
+	req.RemoteAddr = ip + ":40000"
+	req.Header.Set("User-Agent", userAgent)
+	return req
+}
+
+func TestSessionLimits(t *testing.T) {
+	s := newTestServer(t, &config.Config{})
+	addTestFile(t, s, "abc", []byte("0123456789"))
+	if err := s.db.SetFileLimits("abc", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+		path    string
+		ip, ua  string
+		want    int
+	}{
+		{"probe before any session", s.handleStream, http.MethodHead, "/stream/abc", "10.0.0.1", "player", http.StatusOK},
+		{"first stream", s.handleStream, http.MethodGet, "/stream/abc", "10.0.0.1", "player", http.StatusOK},
+		{"range request of the same session", s.handleStream, http.MethodGet, "/stream/abc", "10.0.0.1", "player", http.StatusOK},
+		{"another player", s.handleStream, http.MethodGet, "/stream/abc", "10.0.0.1", "other", http.StatusGone},
+		{"another client", s.handleStream, http.MethodGet, "/stream/abc", "10.0.0.2", "player", http.StatusGone},
+		{"probe of the counted session", s.handleStream, http.MethodHead, "/stream/abc", "10.0.0.1", "player", http.StatusOK},
+		{"probe after the cap", s.handleStream, http.MethodHead, "/stream/abc", "10.0.0.2", "player", http.StatusGone},
+		{"downloads count separately", s.handleDownload, http.MethodGet, "/file/abc", "10.0.0.2", "player", http.StatusOK},
+		{"second download", s.handleDownload, http.MethodGet, "/file/abc", "10.0.0.3", "player", http.StatusGone},
+	}
+	for _, step := range steps {
+		rec := serve(step.handler, fileRequest(step.method, step.path, step.ip, step.ua), "abc")
+		if rec.Code != step.want {
+			t.Errorf("%s: status = %d, want %d", step.name, rec.Code, step.want)
+		}
+	}
+
+	record, err := s.db.GetFileByID("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.StreamCount != 1 || record.DownloadCount != 1 {
+		t.Errorf("counted %d streams and %d downloads, want 1 and 1", record.StreamCount, record.DownloadCount)
+	}
+}
+
+func TestUnlimitedSessions(t *testing.T) {
+	s := newTestServer(t, &config.Config{})
+	addTestFile(t, s, "abc", []byte("0123456789"))
+
+	for i, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		if rec := serve(s.handleStream, fileRequest(http.MethodGet, "/stream/abc", ip, "player"), "abc"); rec.Code != http.StatusOK {
+			t.Errorf("stream %d: status = %d, want %d", i, rec.Code, http.StatusOK)
+		}
+	}
+	record, _ := s.db.GetFileByID("abc")
+	if record.StreamCount != 3 {
+		t.Errorf("StreamCount = %d, want 3", record.StreamCount)
+	}
+}
+
+func TestSessionClaimIsAtomic(t *testing.T) {
+	tracker := newSessionTracker()
+	const requests = 20
+
+	var claims atomic.Int32
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			active, settle, err := tracker.claim(context.Background(), "stream|abc|10.0.0.1|player")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if !active {
+				claims.Add(1)
+				<-release
+				settle(true)
+			}
+		}()
+	}
+	// Give every request the chance to race for the claim
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := claims.Load(); n != 1 {
+		t.Errorf("%d requests claimed the session, want 1", n)
+	}
+}
+
+func TestSessionClaimPassesOnAfterRefusal(t *testing.T) {
+	tracker := newSessionTracker()
+	key := "stream|abc|10.0.0.1|player"
+
+	_, settle, _ := tracker.claim(context.Background(), key)
+	waiter := make(chan bool)
+	go func() {
+		active, settle, _ := tracker.claim(context.Background(), key)
+		if !active {
+			settle(false)
+		}
+		waiter <- active
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	// The first request wasn't allowed to start, so the waiter must try itself
+	settle(false)
+	if <-waiter {
+		t.Error("waiter joined a session that never started")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, settle, _ = tracker.claim(context.Background(), key)
+	cancel()
+	if _, _, err := tracker.claim(ctx, key); err == nil {
+		t.Error("claim() waited on although the client went away")
+	}
+	settle(true)
+	if !tracker.active(key) {
+		t.Error("session not active after it started")
+	}
+}
+
+func TestConcurrentRangeRequestsCountOnce(t *testing.T) {
+	s := newTestServer(t, &config.Config{})
+	addTestFile(t, s, "abc", []byte("0123456789"))
+	if err := s.db.SetFileLimits("abc", 0, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := fileRequest(http.MethodGet, "/stream/abc", "10.0.0.1", "player")
+			req.Header.Set("Range", "bytes=2-5")
+			if rec := serve(s.handleStream, req, "abc"); rec.Code != http.StatusPartialContent {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusPartialContent)
+			}
+		}()
+	}
+	wg.Wait()
+
+	record, err := s.db.GetFileByID("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.StreamCount != 1 {
+		t.Errorf("StreamCount = %d, want 1", record.StreamCount)
+	}
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"hafton-movie-bot/internal/database"
+	"hafton-movie-bot/internal/mediainfo"
+	"hafton-movie-bot/internal/sniff"
+
+	"github.com/gorilla/mux"
+)
+
+// Longest a page waits for a file's type and media info to be read from a
+// file that hasn't been probed yet. It is shown without them after that.
+const mediaProbeTimeout = 5 * time.Second
+
+// probeContext bounds the probing done while answering r
+func probeContext(r *http.Request) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(r.Context(), mediaProbeTimeout)
+}
+
+// ensureFileType detects the type of a file stored with a generic MIME type,
+// so the watch page can pick a player
+func (s *Server) ensureFileType(ctx context.Context, record *database.FileRecord) {
+	if !sniff.IsGeneric(record.FileType) || record.FileSize <= 0 {
+		return
+	}
+
+	content, _, err := s.openFile(ctx, record)
+	if err != nil {
+		return
+	}
+	defer content.Close()
+
+	head := make([]byte, min(int64(sniff.HeaderSize), record.FileSize))
+	if _, err := content.ReadAt(head, 0); err != nil && err != io.EOF {
+		log.Printf("Error reading header of %s: %v", record.ID, err)
+		return
+	}
+	s.correctFileType(record, head)
+}
+
+// ensureMediaInfo probes a file that hasn't been probed yet (e.g. uploaded
+// before probing existed) and stores the result. A probe cut short by ctx
+// leaves the record without media info and is tried again next time.
+func (s *Server) ensureMediaInfo(ctx context.Context, record *database.FileRecord) {
+	if record.Media != nil || record.FileSize <= 0 || !mediainfo.Probeable(record.FileName, record.FileType) {
+		return
+	}
+
+	content, _, err := s.openFile(ctx, record)
+	if err != nil {
+		return
+	}
+	defer content.Close()
+
+	start := time.Now()
+	info, err := mediainfo.Probe(content, record.FileSize)
+	if err != nil && ctx.Err() != nil {
+		log.Printf("Gave up probing %s: %v", record.ID, ctx.Err())
+		return
+	}
+	if err != nil {
+		log.Printf("Could not probe %s: %v", record.ID, err)
+		info = nil
+	} else {
+		log.Printf("Probed %s in %s: %s %s %s", record.ID, time.Since(start).Round(time.Millisecond), info.Container, info.Resolution(), info.Codecs())
+	}
+
+	if err := s.db.UpdateMediaInfo(record.ID, info); err != nil {
+		log.Printf("Error saving media info for %s: %v", record.ID, err)
+	}
+	if info == nil {
+		info = &mediainfo.Info{}
+	}
+	record.Media = info
+}
+
+// fileInfoResponse is the JSON returned by /api/files/{id}
+type fileInfoResponse struct {
+	ID         string          `json:"id"`
+	FileName   string          `json:"file_name"`
+	FileSize   int64           `json:"file_size"`
+	FileType   string          `json:"file_type"`
+	UploadedAt time.Time       `json:"uploaded_at"`
+	ExpiresAt  time.Time       `json:"expires_at"`
+	Media      *mediainfo.Info `json:"media"`
+	Subtitles  []string        `json:"subtitles"`
+}
+
+// handleFileInfo returns a file's details and media metadata as JSON
+func (s *Server) handleFileInfo(w http.ResponseWriter, r *http.Request) {
+	fileID := mux.Vars(r)["id"]
+
+	if !s.checkSignature(w, r, fileID) {
+		return
+	}
+
+	record, err := s.db.GetFileByID(fileID)
+	if err != nil || time.Now().After(record.ExpiresAt) {
+		writeJSONError(w, http.StatusNotFound, "file not found or expired")
+		return
+	}
+
+	if record.PasswordHash != "" && !s.isUnlocked(r, record) {
+		writeJSONError(w, http.StatusUnauthorized, "file is password protected")
+		return
+	}
+
+	ctx, cancel := probeContext(r)
+	s.ensureFileType(ctx, record)
+	s.ensureMediaInfo(ctx, record)
+	cancel()
+
+	response := fileInfoResponse{
+		ID:         record.ID,
+		FileName:   record.FileName,
+		FileSize:   record.FileSize,
+		FileType:   record.FileType,
+		UploadedAt: record.UploadedAt,
+		ExpiresAt:  record.ExpiresAt,
+		Subtitles:  []string{},
+	}
+	if record.Media.Known() {
+		response.Media = record.Media
+	}
+	if subs, err := s.db.GetSubtitles(record.ID); err == nil {
+		for _, sub := range subs {
+			response.Subtitles = append(response.Subtitles, sub.Language)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
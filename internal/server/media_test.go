@@ -0,0 +1,48 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"hafton-movie-bot/internal/config"
+)
+
+func TestFileInfoGivesUpProbing(t *testing.T) {
+	s := newTestServer(t, &config.Config{})
+	upstream := newTestUpstream(t, testContent(4096))
+	upstream.delay = time.Second
+	addProxiedFile(t, s, "abc", upstream.fileURL(), 4096)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "/api/files/abc", nil).WithContext(ctx)
+
+	start := time.Now()
+	rec := serve(s.handleFileInfo, req, "abc")
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("answered after %s, want the probe abandoned with the request", elapsed)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var info fileInfoResponse
+	if err := json.NewDecoder(rec.Body).Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Media != nil {
+		t.Errorf("media = %+v, want none", info.Media)
+	}
+
+	// The file is probed again by the next request
+	record, err := s.db.GetFileByID("abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Media != nil {
+		t.Errorf("stored media = %+v, want none", record.Media)
+	}
+}
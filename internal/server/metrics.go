@@ -0,0 +1,47 @@
+package server
+
+import (
+	"net/http"
+
+	"hafton-movie-bot/internal/database"
+	"hafton-movie-bot/internal/metrics"
+)
+
+// metricsResponseWriter counts the body bytes of a file response
+type metricsResponseWriter struct {
+	http.ResponseWriter
+	endpoint string
+	source   string
+}
+
+func (w *metricsResponseWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	metrics.BytesServed.Add(float64(n), w.endpoint, w.source)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *metricsResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// recordSource labels where a record's bytes come from
+func recordSource(record *database.FileRecord) string {
+	return record.SourceKind
+}
+
+// instrument tracks an active response and the bytes it sends. The returned
+// function must be called when the response is done.
+func (s *Server) instrument(w http.ResponseWriter, endpoint string, record *database.FileRecord) (http.ResponseWriter, func()) {
+	source := recordSource(record)
+	metrics.ActiveStreams.Inc(endpoint, source)
+	return &metricsResponseWriter{ResponseWriter: w, endpoint: endpoint, source: source}, func() {
+		metrics.ActiveStreams.Dec(endpoint, source)
+	}
+}
+
+// handleMetrics serves the Prometheus text format. When a token is configured
+// it must be sent as "Authorization: Bearer <token>".
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	metrics.Handler(s.config.Metrics.Token)(w, r)
+}
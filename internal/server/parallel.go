@@ -0,0 +1,257 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+
+	"hafton-movie-bot/internal/database"
+)
+
+// defaultParallelConnections and defaultParallelChunkSize apply when the
+// parallel section leaves them unset
+const (
+	defaultParallelConnections = 4
+	defaultParallelChunkSize   = 1024 * 1024
+)
+
+// errRangeIgnored is set on a chunk when Telegram answered its ranged request
+// with the whole file
+var errRangeIgnored = errors.New("upstream ignored the range request")
+
+// parallelFile reads a proxied file over several ranged connections at once.
+// Sequential reads keep a window of chunks in flight ahead of the reader and
+// get them back in order; a seek starts over with a single chunk until the
+// reads turn out to be sequential again. If Telegram ignores Range the file is
+// read through one response instead.
+type parallelFile struct {
+	s           *Server
+	ctx         context.Context
+	record      *database.FileRecord
+	chunkSize   int64
+	connections int
+
+	// Called with Telegram's status code whenever a chunk is fetched
+	onUpstream func(status int)
+
+	// Loads a chunk by index instead of a ranged request of its own when set,
+	// so the disk cache and shared buffer are filled a window at a time
+	load func(ctx context.Context, index int64) ([]byte, error)
+
+	mu       sync.Mutex
+	pending  []*parallelChunk // Requested chunks in file order
+	next     int64            // Offset of the next chunk to request, -1 before the first read
+	runStart int64            // Offset the current run of sequential reads began at
+	stream   *streamFile      // Set once Telegram ignored Range
+}
+
+type parallelChunk struct {
+	start, end int64
+	cancel     context.CancelFunc
+	done       chan struct{}
+
+	// Set before done is closed
+	data []byte
+	resp *http.Response // The whole file, with errRangeIgnored
+	err  error
+}
+
+// newParallelFile returns nil unless parallel fetching is enabled and the
+// file's size is known
+func (s *Server) newParallelFile(ctx context.Context, record *database.FileRecord) *parallelFile {
+	cfg := s.config.Parallel
+	if !cfg.Enabled || record.FileSize <= 0 {
+		return nil
+	}
+
+	connections := cfg.Connections
+	if connections <= 0 {
+		connections = defaultParallelConnections
+	}
+	chunkSize := int64(cfg.ChunkSizeKB) * 1024
+	if chunkSize <= 0 {
+		chunkSize = defaultParallelChunkSize
+	}
+	return &parallelFile{
+		s:           s,
+		ctx:         ctx,
+		record:      record,
+		chunkSize:   chunkSize,
+		connections: connections,
+		next:        -1,
+	}
+}
+
+func (f *parallelFile) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	size := f.record.FileSize
+	if off >= size {
+		return 0, io.EOF
+	}
+
+	n := 0
+	for n < len(p) && off+int64(n) < size {
+		pos := off + int64(n)
+		if f.stream != nil {
+			m, err := f.stream.ReadAt(p[n:], pos)
+			return n + m, err
+		}
+
+		chunk := f.chunkAt(pos)
+		select {
+		case <-chunk.done:
+		case <-f.ctx.Done():
+			return n, f.ctx.Err()
+		}
+
+		if errors.Is(chunk.err, errRangeIgnored) {
+			log.Printf("Telegram ignored Range for %s, fetching over a single connection", f.record.ID)
+			f.stream = &streamFile{s: f.s, ctx: f.ctx, record: f.record, onUpstream: f.onUpstream}
+			f.stream.body = f.s.newResumingBody(f.ctx, f.record, chunk.resp)
+			f.pending = f.pending[1:]
+			f.cancelPending()
+			continue
+		}
+		if chunk.err != nil {
+			return n, chunk.err
+		}
+		if pos-chunk.start >= int64(len(chunk.data)) {
+			return n, io.ErrUnexpectedEOF
+		}
+		n += copy(p[n:], chunk.data[pos-chunk.start:])
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// chunkAt returns the requested chunk holding pos, starting new requests to
+// keep the window full
+func (f *parallelFile) chunkAt(pos int64) *parallelChunk {
+	// Drop chunks the reader has moved past
+	for len(f.pending) > 0 && f.pending[0].end < pos {
+		f.discard(f.pending[0])
+		f.pending = f.pending[1:]
+	}
+
+	contiguous := len(f.pending) > 0 && f.pending[0].start <= pos || len(f.pending) == 0 && pos == f.next
+	if !contiguous {
+		f.cancelPending()
+		f.next = pos
+		if f.load != nil {
+			// Loaded chunks start on chunk boundaries
+			f.next -= pos % f.chunkSize
+		}
+		f.runStart = pos
+	}
+
+	// Only go wide once a whole chunk has been read in order, so the small
+	// scattered reads of sniffing and probing cost one request each
+	window := 1
+	if pos-f.runStart >= f.chunkSize {
+		window = f.connections
+	}
+	for len(f.pending) < window && f.next < f.record.FileSize {
+		f.pending = append(f.pending, f.request(f.next))
+		f.next = f.pending[len(f.pending)-1].end + 1
+	}
+	return f.pending[0]
+}
+
+// request starts fetching the chunk beginning at start
+func (f *parallelFile) request(start int64) *parallelChunk {
+	ctx, cancel := context.WithCancel(f.ctx)
+	chunk := &parallelChunk{
+		start:  start,
+		end:    min(start+f.chunkSize, f.record.FileSize) - 1,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	go func() {
+		defer close(chunk.done)
+		if f.load != nil {
+			chunk.data, chunk.err = f.load(ctx, chunk.start/f.chunkSize)
+			return
+		}
+
+		retry := f.s.newUpstreamRetry()
+		for {
+			chunk.data, chunk.resp, chunk.err = f.fetch(ctx, chunk)
+			if chunk.err == nil || errors.Is(chunk.err, errRangeIgnored) || !retry.wait(ctx) {
+				return
+			}
+			log.Printf("Error fetching %s bytes %d-%d: %v, retrying (attempt %d/%d)",
+				f.record.ID, chunk.start, chunk.end, chunk.err, retry.attempt(), retry.max)
+		}
+	}()
+	return chunk
+}
+
+func (f *parallelFile) fetch(ctx context.Context, chunk *parallelChunk) ([]byte, *http.Response, error) {
+	resp, err := f.s.fetchUpstream(ctx, f.record, http.MethodGet, fmt.Sprintf("bytes=%d-%d", chunk.start, chunk.end))
+	if err != nil {
+		return nil, nil, err
+	}
+	if f.onUpstream != nil {
+		f.onUpstream(resp.StatusCode)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		if start, _, ok := spanFromContentRange(resp.Header.Get("Content-Range")); !ok || start != chunk.start {
+			resp.Body.Close()
+			return nil, nil, fmt.Errorf("telegram answered bytes %d-%d with %q", chunk.start, chunk.end, resp.Header.Get("Content-Range"))
+		}
+	case http.StatusOK:
+		return nil, resp, errRangeIgnored
+	default:
+		resp.Body.Close()
+		return nil, nil, fmt.Errorf("telegram returned status %d", resp.StatusCode)
+	}
+	defer resp.Body.Close()
+
+	data := make([]byte, chunk.end-chunk.start+1)
+	if _, err := io.ReadFull(resp.Body, data); err != nil {
+		return nil, nil, fmt.Errorf("failed to read bytes %d-%d: %w", chunk.start, chunk.end, err)
+	}
+	return data, nil, nil
+}
+
+// discard abandons a chunk, aborting its transfer if still running
+func (f *parallelFile) discard(chunk *parallelChunk) {
+	chunk.cancel()
+	go func() {
+		<-chunk.done
+		if chunk.resp != nil {
+			chunk.resp.Body.Close()
+		}
+	}()
+}
+
+func (f *parallelFile) cancelPending() {
+	for _, chunk := range f.pending {
+		f.discard(chunk)
+	}
+	f.pending = nil
+}
+
+// Close aborts the transfers still in flight
+func (f *parallelFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.cancelPending()
+	if f.stream != nil {
+		return f.stream.Close()
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"hafton-movie-bot/internal/config"
+)
+
+func TestParallelFile(t *testing.T) {
+	content := testContent(100000)
+
+	tests := []struct {
+		name        string
+		ignoreRange bool
+		drops       []int64
+		requests    []string
+	}{
+		{
+			name: "ranged chunks",
+			requests: []string{
+				"bytes=0-16383", "bytes=16384-32767", "bytes=32768-49151", "bytes=49152-65535",
+				"bytes=65536-81919", "bytes=81920-98303", "bytes=98304-99999",
+			},
+		},
+		{
+			name:        "range ignored",
+			ignoreRange: true,
+			requests:    []string{"bytes=0-16383"},
+		},
+		{
+			name:        "range ignored and the transfer breaks",
+			ignoreRange: true,
+			drops:       []int64{30000},
+			requests:    []string{"bytes=0-16383", "bytes=30000-99999"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.Parallel.Enabled = true
+			cfg.Parallel.Connections = 3
+			cfg.Parallel.ChunkSizeKB = 16
+			cfg.Upstream.RetryBackoffMS = 1
+			s := newTestServer(t, cfg)
+			upstream := newTestUpstream(t, content)
+			upstream.ignoreRange = tt.ignoreRange
+			upstream.drops = tt.drops
+			record := addProxiedFile(t, s, "abc", upstream.fileURL(), int64(len(content)))
+
+			file := s.newParallelFile(context.Background(), record)
+			defer file.Close()
+			data, err := io.ReadAll(io.NewSectionReader(file, 0, record.FileSize))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, content) {
+				t.Errorf("read %d bytes, want the %d byte file", len(data), len(content))
+			}
+
+			requests := upstream.requests()
+			sort.Strings(requests)
+			if !reflect.DeepEqual(requests, tt.requests) {
+				t.Errorf("upstream requests = %q, want %q", requests, tt.requests)
+			}
+		})
+	}
+}
+
+func TestParallelFileSeek(t *testing.T) {
+	content := testContent(100000)
+	cfg := &config.Config{}
+	cfg.Parallel.Enabled = true
+	cfg.Parallel.ChunkSizeKB = 16
+	s := newTestServer(t, cfg)
+	upstream := newTestUpstream(t, content)
+	record := addProxiedFile(t, s, "abc", upstream.fileURL(), int64(len(content)))
+
+	file := s.newParallelFile(context.Background(), record)
+	defer file.Close()
+
+	// Scattered small reads cost one request each
+	for _, off := range []int64{90000, 10, 50000} {
+		buf := make([]byte, 100)
+		if _, err := file.ReadAt(buf, off); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf, content[off:off+100]) {
+			t.Errorf("ReadAt(%d) returned the wrong bytes", off)
+		}
+	}
+	want := []string{"bytes=90000-99999", "bytes=10-16393", "bytes=50000-66383"}
+	if got := upstream.requests(); !reflect.DeepEqual(got, want) {
+		t.Errorf("upstream requests = %q, want %q", got, want)
+	}
+
+	buf := make([]byte, 100)
+	if n, err := file.ReadAt(buf, 99950); n != 50 || err != io.EOF {
+		t.Errorf("ReadAt past the end = %d, %v, want 50, EOF", n, err)
+	}
+}
+
+func TestCachedFileLoadsInParallel(t *testing.T) {
+	content := testContent(100000)
+	cfg := &config.Config{}
+	cfg.Parallel.Enabled = true
+	cfg.Parallel.Connections = 3
+	cfg.Parallel.ChunkSizeKB = 64 // The cache's chunk size wins
+	s := newTestServer(t, cfg)
+	if err := s.storage.EnableCache(16*1024, 1024*1024); err != nil {
+		t.Fatal(err)
+	}
+	upstream := newTestUpstream(t, content)
+	upstream.delay = 10 * time.Millisecond
+	record := addProxiedFile(t, s, "abc", upstream.fileURL(), int64(len(content)))
+
+	// Start mid-chunk, as a player resuming playback would
+	file := s.newCachedFile(context.Background(), record)
+	data, err := io.ReadAll(io.NewSectionReader(file, 20000, record.FileSize-20000))
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content[20000:]) {
+		t.Errorf("read %d bytes, want the %d from offset 20000", len(data), len(content)-20000)
+	}
+
+	requests := upstream.requests()
+	sort.Strings(requests)
+	want := []string{"bytes=16384-32767", "bytes=32768-49151", "bytes=49152-65535", "bytes=65536-81919", "bytes=81920-98303", "bytes=98304-99999"}
+	if !reflect.DeepEqual(requests, want) {
+		t.Errorf("upstream requests = %q, want %q", requests, want)
+	}
+	if peak := upstream.peakRequests(); peak < 2 || peak > 3 {
+		t.Errorf("%d chunks were loaded at once, want 2 or 3", peak)
+	}
+
+	// Every chunk loaded went to the disk cache
+	for index := int64(1); index < 7; index++ {
+		if !s.storage.Cache().Has(record.ID, index) {
+			t.Errorf("chunk %d is not cached", index)
+		}
+	}
+	file = s.newCachedFile(context.Background(), record)
+	defer file.Close()
+	if _, err := io.ReadAll(io.NewSectionReader(file, 16384, record.FileSize-16384)); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(upstream.requests()); got != len(want) {
+		t.Errorf("cached chunks were fetched again, %d upstream requests", got)
+	}
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"hafton-movie-bot/internal/database"
+	"hafton-movie-bot/internal/utils"
+
+	"github.com/gorilla/mux"
+)
+
+// How long an unlocked file stays accessible without re-entering the password
+const unlockCookieTTL = 6 * time.Hour
+
+// Each unlock attempt costs a PBKDF2 hash, so attempts are capped per client
+// IP and per file within each window
+const (
+	unlockWindow          = time.Minute
+	unlockAttemptsPerIP   = 10
+	unlockAttemptsPerFile = 30
+)
+
+// unlockLimiter counts unlock attempts in fixed windows
+type unlockLimiter struct {
+	mu          sync.Mutex
+	windowStart time.Time
+	perIP       map[string]int
+	perFile     map[string]int
+}
+
+func newUnlockLimiter() *unlockLimiter {
+	return &unlockLimiter{perIP: make(map[string]int), perFile: make(map[string]int)}
+}
+
+// allow records an attempt from ip on fileID. It returns false without
+// recording it once either has used up its attempts for the window.
+func (l *unlockLimiter) allow(ip, fileID string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.windowStart) >= unlockWindow {
+		l.windowStart = now
+		l.perIP = make(map[string]int)
+		l.perFile = make(map[string]int)
+	}
+	if l.perIP[ip] >= unlockAttemptsPerIP || l.perFile[fileID] >= unlockAttemptsPerFile {
+		return false
+	}
+	l.perIP[ip]++
+	l.perFile[fileID]++
+	return true
+}
+
+// localRedirect reports whether next is a path on this site. Browsers treat
+// a backslash like a slash and drop tabs and newlines, so "/\evil.com" or
+// "/\t/evil.com" would leave the site; anything like that is refused.
+func localRedirect(next string) bool {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") {
+		return false
+	}
+	for _, c := range next {
+		if c == '\\' || c < 0x20 || c == 0x7f {
+			return false
+		}
+	}
+	u, err := url.Parse(next)
+	return err == nil && u.Scheme == "" && u.Host == ""
+}
+
+// unlockPage is the data passed to the unlock.html template
+type unlockPage struct {
+	FileName string
+	Action   string
+	Next     string
+	Error    string
+}
+
+// newCookieKey derives the key for unlock cookies from the signing secret, or
+// generates a random one (cookies then last until the next restart)
+func newCookieKey(secret string) []byte {
+	if secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte("unlock-cookie"))
+		return mac.Sum(nil)
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		log.Fatalf("Failed to generate cookie key: %v", err)
+	}
+	return key
+}
+
+func unlockCookieName(fileID string) string {
+	return "unlock_" + fileID
+}
+
+// unlockToken signs the file ID and expiry. The password hash is mixed in so
+// changing the password invalidates existing cookies.
+func (s *Server) unlockToken(record *database.FileRecord, expires int64) string {
+	mac := hmac.New(sha256.New, s.cookieKey)
+	fmt.Fprintf(mac, "%s:%d:%s", record.ID, expires, record.PasswordHash)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// isUnlocked reports whether the request carries a valid unlock cookie
+func (s *Server) isUnlocked(r *http.Request, record *database.FileRecord) bool {
+	cookie, err := r.Cookie(unlockCookieName(record.ID))
+	if err != nil {
+		return false
+	}
+
+	expiresStr, token, ok := strings.Cut(cookie.Value, ".")
+	if !ok {
+		return false
+	}
+	expires, err := strconv.ParseInt(expiresStr, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	return hmac.Equal([]byte(token), []byte(s.unlockToken(record, expires)))
+}
+
+// checkPassword lets the request through if the file has no password or was
+// unlocked. Otherwise it shows the unlock form and returns false.
+func (s *Server) checkPassword(w http.ResponseWriter, r *http.Request, record *database.FileRecord) bool {
+	if record.PasswordHash == "" || s.isUnlocked(r, record) {
+		return true
+	}
+
+	s.renderTemplate(w, http.StatusUnauthorized, "unlock.html", unlockPage{
+		FileName: record.FileName,
+		Action:   "/unlock/" + record.ID + signedQuery(r),
+		Next:     r.URL.RequestURI(),
+	})
+	return false
+}
+
+func (s *Server) handleUnlock(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	fileID := vars["id"]
+
+	if !s.checkSignature(w, r, fileID) {
+		return
+	}
+
+	record, err := s.db.GetFileByID(fileID)
+	if err != nil || time.Now().After(record.ExpiresAt) {
+		s.serveExpiredPage(w, r)
+		return
+	}
+
+	// Only redirect within this site
+	next := r.FormValue("next")
+	if !localRedirect(next) {
+		next = "/watch/" + record.ID + signedQuery(r)
+	}
+
+	if record.PasswordHash != "" && !s.unlocks.allow(s.clientIP(r), record.ID) {
+		log.Printf("Too many unlock attempts for %s from %s", fileID, s.clientIP(r))
+		w.Header().Set("Retry-After", strconv.Itoa(int(unlockWindow.Seconds())))
+		s.renderTemplate(w, http.StatusTooManyRequests, "unlock.html", unlockPage{
+			FileName: record.FileName,
+			Action:   "/unlock/" + record.ID + signedQuery(r),
+			Next:     next,
+			Error:    "Too many attempts, please wait a minute and try again.",
+		})
+		return
+	}
+
+	if record.PasswordHash != "" && !utils.CheckPassword(record.PasswordHash, r.FormValue("password")) {
+		log.Printf("Wrong password for %s", fileID)
+		s.renderTemplate(w, http.StatusUnauthorized, "unlock.html", unlockPage{
+			FileName: record.FileName,
+			Action:   "/unlock/" + record.ID + signedQuery(r),
+			Next:     next,
+			Error:    "Wrong password, please try again.",
+		})
+		return
+	}
+
+	expires := time.Now().Add(unlockCookieTTL)
+	http.SetCookie(w, &http.Cookie{
+		Name:     unlockCookieName(record.ID),
+		Value:    strconv.FormatInt(expires.Unix(), 10) + "." + s.unlockToken(record, expires.Unix()),
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
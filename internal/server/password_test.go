@@ -0,0 +1,160 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"hafton-movie-bot/internal/config"
+	"hafton-movie-bot/internal/utils"
+)
+
+// unlockFrom posts a password to the unlock form of a file from a client IP
+func unlockFrom(s *Server, id, password, ip string) *httptest.ResponseRecorder {
+	form := url.Values{"password": {password}}
+	req := httptest.NewRequest(http.MethodPost, "/unlock/"+id, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.RemoteAddr = ip + ":40000"
+	return serve(s.handleUnlock, req, id)
+}
+
+// unlock posts a password to the unlock form of a file
+func unlock(s *Server, id, password, next string) *httptest.ResponseRecorder {
+	form := url.Values{"password": {password}, "next": {next}}
+	req := httptest.NewRequest(http.MethodPost, "/unlock/"+id, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return serve(s.handleUnlock, req, id)
+}
+
+func TestPasswordProtectedFile(t *testing.T) {
+	s := newTestServer(t, &config.Config{})
+	record := addTestFile(t, s, "abc", []byte("0123456789"))
+	hash, err := utils.HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.db.SetFilePassword(record.ID, hash); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := serve(s.handleStream, httptest.NewRequest(http.MethodGet, "/stream/abc", nil), "abc"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("locked stream status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if rec := unlock(s, "abc", "wrong", "/stream/abc"); rec.Code != http.StatusUnauthorized || len(rec.Result().Cookies()) != 0 {
+		t.Fatalf("wrong password: status %d with %d cookies", rec.Code, len(rec.Result().Cookies()))
+	}
+
+	rec := unlock(s, "abc", "secret", "/stream/abc")
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/stream/abc" {
+		t.Fatalf("unlock: status %d, Location %q", rec.Code, rec.Header().Get("Location"))
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != unlockCookieName("abc") || !cookies[0].HttpOnly {
+		t.Fatalf("unlock cookies = %v", cookies)
+	}
+	value := cookies[0].Value
+	expires, token, _ := strings.Cut(value, ".")
+	later, _ := strconv.ParseInt(expires, 10, 64)
+	later += 3600
+	tampered := []byte(value)
+	tampered[len(tampered)-1] ^= 1
+
+	tests := []struct {
+		name   string
+		cookie string
+		value  string
+		want   int
+	}{
+		{"valid cookie", "abc", value, http.StatusOK},
+		{"tampered token", "abc", string(tampered), http.StatusUnauthorized},
+		{"extended expiry", "abc", strconv.FormatInt(later, 10) + "." + token, http.StatusUnauthorized},
+		{"expired", "abc", "1." + s.unlockToken(record, 1), http.StatusUnauthorized},
+		{"no expiry", "abc", token, http.StatusUnauthorized},
+		{"other file's cookie", "abd", value, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/stream/abc", nil)
+			req.AddCookie(&http.Cookie{Name: unlockCookieName(tt.cookie), Value: tt.value})
+			if rec := serve(s.handleStream, req, "abc"); rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+
+	// Changing the password invalidates cookies issued for the old one
+	hash, _ = utils.HashPassword("changed")
+	s.db.SetFilePassword(record.ID, hash)
+	req := httptest.NewRequest(http.MethodGet, "/stream/abc", nil)
+	req.AddCookie(&http.Cookie{Name: unlockCookieName("abc"), Value: value})
+	if rec := serve(s.handleStream, req, "abc"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("cookie for the old password: status %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestUnlockRedirectStaysOnSite(t *testing.T) {
+	s := newTestServer(t, &config.Config{})
+	addTestFile(t, s, "abc", []byte("0123456789"))
+
+	tests := []struct {
+		next string
+		want string
+	}{
+		{"/stream/abc", "/stream/abc"},
+		{"https://evil.example/", "/watch/abc"},
+		{"//evil.example/", "/watch/abc"},
+		{"/\\evil.example/", "/watch/abc"},
+		{"/\\/evil.example/", "/watch/abc"},
+		{"/\t/evil.example/", "/watch/abc"},
+		{"/\n/evil.example/", "/watch/abc"},
+		{"/watch/abc?e=1&sig=00", "/watch/abc?e=1&sig=00"},
+		{"", "/watch/abc"},
+	}
+	for _, tt := range tests {
+		if rec := unlock(s, "abc", "", tt.next); rec.Header().Get("Location") != tt.want {
+			t.Errorf("next %q redirected to %q, want %q", tt.next, rec.Header().Get("Location"), tt.want)
+		}
+	}
+}
+
+func TestUnlockRateLimit(t *testing.T) {
+	s := newTestServer(t, &config.Config{})
+	for _, id := range []string{"abc", "def"} {
+		addTestFile(t, s, id, []byte("0123456789"))
+		hash, _ := utils.HashPassword("secret")
+		s.db.SetFilePassword(id, hash)
+	}
+
+	for i := 0; i < unlockAttemptsPerIP; i++ {
+		if rec := unlockFrom(s, "abc", "wrong", "10.0.0.1"); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: status = %d, want %d", i+1, rec.Code, http.StatusUnauthorized)
+		}
+	}
+	// Even the right password waits once the client's attempts are used up
+	rec := unlockFrom(s, "def", "secret", "10.0.0.1")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "60" {
+		t.Errorf("attempt past the cap: status %d, Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := unlockFrom(s, "def", "secret", "10.0.0.2"); rec.Code != http.StatusSeeOther {
+		t.Errorf("another client: status = %d, want %d", rec.Code, http.StatusSeeOther)
+	}
+
+	// Guessing from many addresses runs into the per-file cap
+	for i := 0; i < unlockAttemptsPerFile-unlockAttemptsPerIP; i++ {
+		unlockFrom(s, "abc", "wrong", fmt.Sprintf("10.1.0.%d", i))
+	}
+	if rec := unlockFrom(s, "abc", "secret", "10.2.0.1"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("attempt past the file's cap: status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+
+	// The next window starts over
+	s.unlocks.windowStart = time.Now().Add(-unlockWindow)
+	if rec := unlockFrom(s, "abc", "secret", "10.0.0.1"); rec.Code != http.StatusSeeOther {
+		t.Errorf("next window: status = %d, want %d", rec.Code, http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"hafton-movie-bot/internal/database"
+	"hafton-movie-bot/internal/utils"
+
+	"github.com/gorilla/mux"
+)
+
+// handlePlaylist serves a playlist as an extended M3U pointing at the
+// files' stream links. The unguessable token is the only credential, so
+// only files of the user who created the playlist are ever listed.
+func (s *Server) handlePlaylist(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+
+	playlist, err := s.db.GetPlaylist(token)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Error loading playlist: %v", err)
+		}
+		http.NotFound(w, r)
+		return
+	}
+
+	records, err := s.playlistFiles(playlist)
+	if err != nil {
+		log.Printf("Error loading playlist files for user %d: %v", playlist.TelegramUserID, err)
+		http.Error(w, "Failed to load playlist", http.StatusInternalServerError)
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	for _, record := range records {
+		duration := -1
+		if record.Media != nil && record.Media.Duration > 0 {
+			duration = int(math.Round(record.Media.Duration))
+		}
+		fmt.Fprintf(&b, "#EXTINF:%d,%s\n", duration, playlistTitle(record.FileName))
+		fmt.Fprintf(&b, "https://%s/stream/%s%s\n", s.domain, record.ID, s.signedFileQuery(record))
+	}
+
+	ext := "m3u8"
+	if strings.HasSuffix(r.URL.Path, ".m3u") {
+		ext = "m3u"
+	}
+	w.Header().Set("Content-Type", "audio/x-mpegurl; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="playlist.%s"`, ext))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte(b.String()))
+}
+
+// playlistFiles returns the playable files of a playlist. Expired and
+// password protected files are left out; external players can't unlock them.
+func (s *Server) playlistFiles(playlist *database.Playlist) ([]*database.FileRecord, error) {
+	var records []*database.FileRecord
+	if len(playlist.FileIDs) == 0 {
+		active, err := s.db.GetActiveFilesByUser(playlist.TelegramUserID)
+		if err != nil {
+			return nil, err
+		}
+		records = active
+	} else {
+		for _, fileID := range playlist.FileIDs {
+			record, err := s.db.GetFileByID(fileID)
+			if err != nil || record.TelegramUserID != playlist.TelegramUserID || time.Now().After(record.ExpiresAt) {
+				continue
+			}
+			records = append(records, record)
+		}
+	}
+
+	playable := records[:0]
+	for _, record := range records {
+		if record.PasswordHash == "" {
+			playable = append(playable, record)
+		}
+	}
+	return playable, nil
+}
+
+// signedFileQuery signs a file's stream link the same way the bot does
+func (s *Server) signedFileQuery(record *database.FileRecord) string {
+	secret := s.config.Security.SigningSecret
+	if secret == "" {
+		return ""
+	}
+
+	expires := record.ExpiresAt
+	if ttl := s.config.Security.LinkTTLHours; ttl > 0 {
+		if limit := time.Now().Add(time.Duration(ttl) * time.Hour); limit.Before(expires) {
+			expires = limit
+		}
+	}
+	return utils.SignedQuery(secret, record.ID, expires)
+}
+
+// playlistTitle keeps a file name from breaking the playlist's line structure
+func playlistTitle(fileName string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(fileName)
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// contentRange formats the Content-Range value for a single range
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.end, size)
+}
+
+func (r byteRange) length() int64 {
+	return r.end - r.start + 1
+}
+
+// rangePartHeader builds the MIME headers for one part of a multipart/byteranges body
+func rangePartHeader(r byteRange, contentType string, size int64) textproto.MIMEHeader {
+	return textproto.MIMEHeader{
+		"Content-Type":  {contentType},
+		"Content-Range": {r.contentRange(size)},
+	}
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter int64
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w += countingWriter(len(p))
+	return len(p), nil
+}
+
+// multipartSize returns the exact body length of a multipart/byteranges response
+// so that Content-Length can be sent up front
+func multipartSize(ranges []byteRange, boundary, contentType string, size int64) int64 {
+	var cw countingWriter
+	mw := multipart.NewWriter(&cw)
+	mw.SetBoundary(boundary)
+	for _, ra := range ranges {
+		mw.CreatePart(rangePartHeader(ra, contentType, size))
+		cw += countingWriter(ra.length())
+	}
+	mw.Close()
+	return int64(cw)
+}
+
+// serveRangeNotSatisfiable answers a Range request that does not overlap the file
+func serveRangeNotSatisfiable(w http.ResponseWriter, size int64) {
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+	http.Error(w, "Requested range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+}
+
+// serveMultipartRanges writes a multipart/byteranges response (RFC 7233 section 4.1)
+// containing every requested range of the file
+func serveMultipartRanges(w http.ResponseWriter, r *http.Request, file io.ReaderAt, ranges []byteRange, contentType string, size int64) {
+	mw := multipart.NewWriter(w)
+	boundary := mw.Boundary()
+
+	w.Header().Set("Content-Type", "multipart/byteranges; boundary="+boundary)
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Content-Length", strconv.FormatInt(multipartSize(ranges, boundary, contentType, size), 10))
+	w.WriteHeader(http.StatusPartialContent)
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	for _, ra := range ranges {
+		part, err := mw.CreatePart(rangePartHeader(ra, contentType, size))
+		if err != nil {
+			return
+		}
+		if _, err := io.Copy(part, io.NewSectionReader(file, ra.start, ra.length())); err != nil {
+			log.Printf("Error writing range %d-%d: %v", ra.start, ra.end, err)
+			return
+		}
+	}
+	mw.Close()
+}
+
+// totalFromContentRange extracts the complete length from a Content-Range
+// value such as "bytes 0-0/1234". It returns -1 if the length is unknown.
+func totalFromContentRange(value string) int64 {
+	slash := strings.LastIndex(value, "/")
+	if slash == -1 {
+		return -1
+	}
+	size, err := strconv.ParseInt(strings.TrimSpace(value[slash+1:]), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return size
+}
+
+// spanFromContentRange extracts the first and last byte from a Content-Range
+// value such as "bytes 100-199/1234"
+func spanFromContentRange(value string) (start, end int64, ok bool) {
+	span, _, _ := strings.Cut(strings.TrimPrefix(value, "bytes "), "/")
+	first, last, found := strings.Cut(span, "-")
+	if !found {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(strings.TrimSpace(first), 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	end, err = strconv.ParseInt(strings.TrimSpace(last), 10, 64)
+	if err != nil || end < start {
+		return 0, 0, false
+	}
+	return start, end, true
+}
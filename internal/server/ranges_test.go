@@ -0,0 +1,150 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []byteRange
+	}{
+		{"bytes=0-99", []byteRange{{0, 99}}},
+		{"bytes=100-", []byteRange{{100, 999}}},
+		{"bytes=-100", []byteRange{{900, 999}}},
+		{"bytes=-5000", []byteRange{{0, 999}}},
+		{"bytes=900-5000", []byteRange{{900, 999}}},
+		{"bytes=0-0, 10-19 ,-1", []byteRange{{0, 0}, {10, 19}, {999, 999}}},
+		{"bytes=1000-", nil},
+		{"bytes=1000-1010", nil},
+		{"bytes=20-10", nil},
+		{"bytes=abc-def,5-6", []byteRange{{5, 6}}},
+		{"bytes=-0", nil},
+		{"bytes=-", nil},
+		{"bytes=", nil},
+		{"items=0-9", nil},
+	}
+
+	for _, tt := range tests {
+		if got := parseRange(tt.header, 1000); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseRange(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestServeMultipartRanges(t *testing.T) {
+	content := make([]byte, 5000)
+	for i := range content {
+		content[i] = byte(i * 11)
+	}
+
+	tests := []struct {
+		name        string
+		ranges      []byteRange
+		contentType string
+	}{
+		{"two ranges", []byteRange{{0, 9}, {100, 199}}, "video/mp4"},
+		{"single bytes", []byteRange{{0, 0}, {1, 1}, {4999, 4999}}, "application/octet-stream"},
+		{"large and overlapping", []byteRange{{0, 4999}, {2500, 3999}}, "video/x-matroska"},
+		{"long content type", []byteRange{{10, 20}, {30, 40}}, "audio/mp4; codecs=\"mp4a.40.2\""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/file/x", nil)
+			rec := httptest.NewRecorder()
+			serveMultipartRanges(rec, req, bytes.NewReader(content), tt.ranges, tt.contentType, int64(len(content)))
+
+			if rec.Code != http.StatusPartialContent {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusPartialContent)
+			}
+			length, _ := strconv.Atoi(rec.Header().Get("Content-Length"))
+			if length != rec.Body.Len() {
+				t.Errorf("Content-Length = %d, but %d bytes were written", length, rec.Body.Len())
+			}
+
+			mediaType, params, err := mime.ParseMediaType(rec.Header().Get("Content-Type"))
+			if err != nil || mediaType != "multipart/byteranges" {
+				t.Fatalf("Content-Type = %q", rec.Header().Get("Content-Type"))
+			}
+			if size := multipartSize(tt.ranges, params["boundary"], tt.contentType, int64(len(content))); size != int64(rec.Body.Len()) {
+				t.Errorf("multipartSize() = %d, but %d bytes were written", size, rec.Body.Len())
+			}
+
+			mr := multipart.NewReader(rec.Body, params["boundary"])
+			for i, ra := range tt.ranges {
+				part, err := mr.NextPart()
+				if err != nil {
+					t.Fatalf("part %d: %v", i, err)
+				}
+				if got := part.Header.Get("Content-Range"); got != ra.contentRange(int64(len(content))) {
+					t.Errorf("part %d Content-Range = %q, want %q", i, got, ra.contentRange(int64(len(content))))
+				}
+				if got := part.Header.Get("Content-Type"); got != tt.contentType {
+					t.Errorf("part %d Content-Type = %q, want %q", i, got, tt.contentType)
+				}
+				data, _ := io.ReadAll(part)
+				if !bytes.Equal(data, content[ra.start:ra.end+1]) {
+					t.Errorf("part %d holds the wrong bytes", i)
+				}
+			}
+			if _, err := mr.NextPart(); err != io.EOF {
+				t.Errorf("expected no more parts, got %v", err)
+			}
+
+			// HEAD announces the same length without a body
+			req = httptest.NewRequest(http.MethodHead, "/file/x", nil)
+			head := httptest.NewRecorder()
+			serveMultipartRanges(head, req, bytes.NewReader(content), tt.ranges, tt.contentType, int64(len(content)))
+			if head.Header().Get("Content-Length") != rec.Header().Get("Content-Length") || head.Body.Len() != 0 {
+				t.Errorf("HEAD Content-Length = %q with %d body bytes, want %q and none",
+					head.Header().Get("Content-Length"), head.Body.Len(), rec.Header().Get("Content-Length"))
+			}
+		})
+	}
+}
+
+func TestRangeAllowed(t *testing.T) {
+	modified := time.Date(2024, 3, 1, 12, 30, 45, 500, time.UTC)
+	etag := `"abc-3e8"`
+	date := modified.Format(http.TimeFormat)
+
+	tests := []struct {
+		name     string
+		ifRange  string
+		modified time.Time
+		want     bool
+	}{
+		{"no If-Range", "", modified, true},
+		{"matching ETag", etag, modified, true},
+		{"other ETag", `"abc-3e9"`, modified, false},
+		{"weak ETag", "W/" + etag, modified, false},
+		{"matching date", date, modified, true},
+		{"older date", modified.Add(-time.Hour).Format(http.TimeFormat), modified, false},
+		{"invalid date", "yesterday", modified, false},
+		{"faststart with matching ETag", etag, time.Time{}, true},
+		{"faststart with a date", date, time.Time{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/file/abc", nil)
+			req.Header.Set("Range", "bytes=0-9")
+			if tt.ifRange != "" {
+				req.Header.Set("If-Range", tt.ifRange)
+			}
+			if got := rangeAllowed(req, etag, tt.modified); got != tt.want {
+				t.Errorf("rangeAllowed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
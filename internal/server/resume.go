@@ -0,0 +1,229 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"hafton-movie-bot/internal/database"
+	"hafton-movie-bot/internal/metrics"
+)
+
+// defaultUpstreamRetries and defaultRetryBackoff apply when the upstream
+// section of the config is unset
+const (
+	defaultUpstreamRetries = 3
+	defaultRetryBackoff    = 500 * time.Millisecond
+)
+
+// upstreamRetry is the reconnect budget for one transfer from Telegram
+type upstreamRetry struct {
+	max     int
+	left    int
+	first   time.Duration
+	backoff time.Duration
+}
+
+func (s *Server) newUpstreamRetry() *upstreamRetry {
+	retries := s.config.Upstream.Retries
+	switch {
+	case retries == 0:
+		retries = defaultUpstreamRetries
+	case retries < 0:
+		retries = 0
+	}
+	backoff := time.Duration(s.config.Upstream.RetryBackoffMS) * time.Millisecond
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+	return &upstreamRetry{max: retries, left: retries, first: backoff, backoff: backoff}
+}
+
+// wait sleeps before the next attempt. It returns false once the budget is
+// spent or ctx is done.
+func (u *upstreamRetry) wait(ctx context.Context) bool {
+	if u.left == 0 || ctx.Err() != nil {
+		return false
+	}
+	u.left--
+
+	timer := time.NewTimer(u.backoff)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		return false
+	}
+	u.backoff *= 2
+	return true
+}
+
+// attempt is the number of the retry in progress, starting at 1
+func (u *upstreamRetry) attempt() int {
+	return u.max - u.left
+}
+
+// reset restores the full budget once a transfer is making progress again,
+// so only consecutive failures count against it
+func (u *upstreamRetry) reset() {
+	u.left = u.max
+	u.backoff = u.first
+}
+
+// fetchUpstreamRetrying is fetchUpstream retried with backoff while Telegram
+// can't be reached or answers with a server error
+func (s *Server) fetchUpstreamRetrying(ctx context.Context, record *database.FileRecord, method, rangeHeader string) (*http.Response, error) {
+	retry := s.newUpstreamRetry()
+	for {
+		resp, err := s.fetchUpstream(ctx, record, method, rangeHeader)
+		if err == nil && resp.StatusCode < 500 {
+			return resp, nil
+		}
+		if err == nil {
+			resp.Body.Close()
+			err = fmt.Errorf("telegram returned status %d", resp.StatusCode)
+		}
+		if !retry.wait(ctx) {
+			return nil, err
+		}
+		log.Printf("Error fetching %s from Telegram: %v, retrying (attempt %d/%d)", record.ID, err, retry.attempt(), retry.max)
+	}
+}
+
+// resumingBody forwards a proxied response body. When the connection to
+// Telegram breaks it requests the rest of the range from the last byte
+// forwarded, so the client sees one uninterrupted body.
+type resumingBody struct {
+	s      *Server
+	ctx    context.Context
+	record *database.FileRecord
+	body   io.ReadCloser
+	offset int64 // File offset of the next byte to forward
+	end    int64 // Last byte of the response, -1 if unknown
+	retry  *upstreamRetry
+	err    error // Set once resuming has failed
+}
+
+// newResumingBody wraps the body of a successful upstream response. Other
+// responses are returned unwrapped since there is nothing to resume.
+func (s *Server) newResumingBody(ctx context.Context, record *database.FileRecord, resp *http.Response) io.ReadCloser {
+	body := &resumingBody{s: s, ctx: ctx, record: record, body: resp.Body, end: -1, retry: s.newUpstreamRetry()}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		switch {
+		case resp.ContentLength >= 0:
+			body.end = resp.ContentLength - 1
+		case record.FileSize > 0:
+			body.end = record.FileSize - 1
+		}
+	case http.StatusPartialContent:
+		start, end, ok := spanFromContentRange(resp.Header.Get("Content-Range"))
+		if !ok {
+			return resp.Body
+		}
+		body.offset, body.end = start, end
+	default:
+		return resp.Body
+	}
+	return body
+}
+
+func (b *resumingBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	n, err := b.body.Read(p)
+	b.offset += int64(n)
+	if n > 0 {
+		b.retry.reset()
+	}
+	switch {
+	case err == nil:
+		return n, nil
+	case err == io.EOF && (b.end < 0 || b.offset > b.end):
+		return n, io.EOF
+	case err == io.EOF:
+		err = io.ErrUnexpectedEOF
+	}
+
+	if err := b.resume(err); err != nil {
+		b.err = err
+		if n > 0 {
+			return n, nil
+		}
+		return 0, err
+	}
+	if n == 0 {
+		return b.Read(p)
+	}
+	return n, nil
+}
+
+// resume replaces the broken upstream connection with a ranged request for
+// the remaining bytes
+func (b *resumingBody) resume(cause error) error {
+	b.body.Close()
+	b.body = http.NoBody
+
+	rangeHeader := fmt.Sprintf("bytes=%d-", b.offset)
+	if b.end >= 0 {
+		rangeHeader = fmt.Sprintf("bytes=%d-%d", b.offset, b.end)
+	}
+
+	for b.retry.wait(b.ctx) {
+		log.Printf("Telegram transfer of %s broke at byte %d (%v), resuming (attempt %d/%d)",
+			b.record.ID, b.offset, cause, b.retry.attempt(), b.retry.max)
+
+		resp, err := b.s.fetchUpstream(b.ctx, b.record, http.MethodGet, rangeHeader)
+		if err != nil {
+			cause = err
+			continue
+		}
+		if err := b.skipTo(resp); err != nil {
+			resp.Body.Close()
+			cause = err
+			continue
+		}
+
+		metrics.UpstreamResumes.Inc("resumed")
+		b.body = resp.Body
+		return nil
+	}
+
+	if b.ctx.Err() != nil {
+		// The client went away; nobody is waiting for the rest
+		return cause
+	}
+	metrics.UpstreamResumes.Inc("gave_up")
+	log.Printf("Giving up on %s at byte %d after %d retries: %v", b.record.ID, b.offset, b.retry.max, cause)
+	return fmt.Errorf("upstream transfer failed at byte %d: %w", b.offset, cause)
+}
+
+// skipTo checks that a resumed response continues at the current offset,
+// discarding the start of the file if Telegram ignored the range
+func (b *resumingBody) skipTo(resp *http.Response) error {
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		start, _, ok := spanFromContentRange(resp.Header.Get("Content-Range"))
+		if !ok || start != b.offset {
+			return fmt.Errorf("telegram resumed at %q instead of byte %d", resp.Header.Get("Content-Range"), b.offset)
+		}
+		return nil
+	case http.StatusOK:
+		if _, err := io.CopyN(io.Discard, resp.Body, b.offset); err != nil {
+			return fmt.Errorf("failed to skip to byte %d: %w", b.offset, err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("telegram returned status %d", resp.StatusCode)
+	}
+}
+
+func (b *resumingBody) Close() error {
+	return b.body.Close()
+}
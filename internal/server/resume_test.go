@@ -0,0 +1,173 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"hafton-movie-bot/internal/config"
+)
+
+func TestResumingBody(t *testing.T) {
+	content := testContent(100000)
+
+	tests := []struct {
+		name        string
+		rangeHeader string
+		drops       []int64
+		ignoreRange bool
+		want        []byte
+		requests    []string
+	}{
+		{
+			name:     "whole file",
+			drops:    []int64{30000},
+			want:     content,
+			requests: []string{"", "bytes=30000-99999"},
+		},
+		{
+			name:        "range",
+			rangeHeader: "bytes=10000-59999",
+			drops:       []int64{5000, 20000},
+			want:        content[10000:60000],
+			requests:    []string{"bytes=10000-59999", "bytes=15000-59999", "bytes=35000-59999"},
+		},
+		{
+			name:        "upstream ignores the resumed range",
+			drops:       []int64{40000},
+			ignoreRange: true,
+			want:        content,
+			requests:    []string{"", "bytes=40000-99999"},
+		},
+		{
+			name:     "progress restores the retry budget",
+			drops:    []int64{10000, 10000, 10000, 10000},
+			want:     content,
+			requests: []string{"", "bytes=10000-99999", "bytes=20000-99999", "bytes=30000-99999", "bytes=40000-99999"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.Upstream.Retries = 2
+			cfg.Upstream.RetryBackoffMS = 1
+			s := newTestServer(t, cfg)
+			upstream := newTestUpstream(t, content)
+			upstream.drops = tt.drops
+			upstream.ignoreRange = tt.ignoreRange
+			record := addProxiedFile(t, s, "abc", upstream.fileURL(), int64(len(content)))
+
+			resp, err := s.fetchUpstream(context.Background(), record, http.MethodGet, tt.rangeHeader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body := s.newResumingBody(context.Background(), record, resp)
+			defer body.Close()
+
+			data, err := io.ReadAll(body)
+			if err != nil {
+				t.Fatalf("reading the body: %v", err)
+			}
+			if !bytes.Equal(data, tt.want) {
+				t.Errorf("got %d bytes, want %d matching bytes", len(data), len(tt.want))
+			}
+			if got := upstream.requests(); !reflect.DeepEqual(got, tt.requests) {
+				t.Errorf("upstream requests = %q, want %q", got, tt.requests)
+			}
+		})
+	}
+}
+
+func TestResumingBodyGivesUp(t *testing.T) {
+	content := testContent(100000)
+	cfg := &config.Config{}
+	cfg.Upstream.Retries = 2
+	cfg.Upstream.RetryBackoffMS = 1
+	s := newTestServer(t, cfg)
+	upstream := newTestUpstream(t, content)
+	upstream.drops = []int64{30000, 0, 0}
+	record := addProxiedFile(t, s, "abc", upstream.fileURL(), int64(len(content)))
+
+	resp, err := s.fetchUpstream(context.Background(), record, http.MethodGet, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := s.newResumingBody(context.Background(), record, resp)
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err == nil {
+		t.Fatal("reading the body succeeded after the retries ran out")
+	}
+	if !bytes.Equal(data, content[:30000]) {
+		t.Errorf("got %d bytes before the error, want the 30000 sent", len(data))
+	}
+	if got := len(upstream.requests()); got != 3 {
+		t.Errorf("upstream got %d requests, want 3", got)
+	}
+}
+
+func TestFetchUpstreamRetrying(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Upstream.RetryBackoffMS = 1
+	s := newTestServer(t, cfg)
+
+	failures := 2
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failures > 0 {
+			failures--
+			http.Error(w, "busy", http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+	record := addProxiedFile(t, s, "abc", upstream.URL, 2)
+
+	resp, err := s.fetchUpstreamRetrying(httptest.NewRequest(http.MethodGet, "/", nil).Context(), record, http.MethodGet, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || failures != 0 {
+		t.Errorf("status = %d with %d failures left, want 200 after both", resp.StatusCode, failures)
+	}
+
+	failures = 10
+	if _, err := s.fetchUpstreamRetrying(httptest.NewRequest(http.MethodGet, "/", nil).Context(), record, http.MethodGet, ""); err == nil {
+		t.Error("fetchUpstreamRetrying() succeeded although every attempt failed")
+	}
+	if failures != 10-4 {
+		t.Errorf("made %d attempts, want 4", 10-failures)
+	}
+}
+
+func TestContentRangeParsing(t *testing.T) {
+	tests := []struct {
+		value      string
+		start, end int64
+		ok         bool
+		total      int64
+	}{
+		{"bytes 0-99/1000", 0, 99, true, 1000},
+		{"bytes 100-199/*", 100, 199, true, -1},
+		{"bytes */1000", 0, 0, false, 1000},
+		{"bytes 200-100/1000", 0, 0, false, 1000},
+		{"", 0, 0, false, -1},
+	}
+
+	for _, tt := range tests {
+		start, end, ok := spanFromContentRange(tt.value)
+		if start != tt.start || end != tt.end || ok != tt.ok {
+			t.Errorf("spanFromContentRange(%q) = %d, %d, %v, want %d, %d, %v", tt.value, start, end, ok, tt.start, tt.end, tt.ok)
+		}
+		if total := totalFromContentRange(tt.value); total != tt.total {
+			t.Errorf("totalFromContentRange(%q) = %d, want %d", tt.value, total, tt.total)
+		}
+	}
+}
@@ -1,19 +1,29 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"hafton-movie-bot/internal/accesslog"
 	"hafton-movie-bot/internal/config"
 	"hafton-movie-bot/internal/database"
+	"hafton-movie-bot/internal/metrics"
+	"hafton-movie-bot/internal/sniff"
 	"hafton-movie-bot/internal/storage"
+	"hafton-movie-bot/internal/throttle"
 
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/gorilla/mux"
 )
 
@@ -22,24 +32,103 @@ type Server struct {
 	storage *storage.Storage
 	config  *config.Config
 	domain  string
+
+	// Bot API client used to refresh expired file links
+	apiMu sync.Mutex
+	api   *tgbotapi.BotAPI
+
+	// Guards records' SourceLocator and TelegramURLUpdatedAt, which a link
+	// refresh replaces while transfers for the record are reading them
+	locatorMu sync.RWMutex
+
+	// Key for signing unlock cookies of password protected files
+	cookieKey []byte
+
+	// Attempts at unlocking password protected files
+	unlocks *unlockLimiter
+
+	// Active stream/download sessions for per-link limits
+	sessions *sessionTracker
+
+	// Bandwidth shaping for response bodies
+	shaper *throttle.Shaper
+
+	// Concurrent connection limits
+	connections *connLimiter
+
+	// Proxies whose X-Forwarded-For / X-Real-IP headers are believed
+	trustedProxies []*net.IPNet
+
+	// JSON access log (nil when disabled)
+	accessLog *accesslog.File
+
+	// TLS certificate when serving HTTPS directly (nil otherwise)
+	certs *certReloader
+
+	// Faststart layouts of MP4s with moov at the end
+	faststart *faststartCache
+
+	// Upstream chunks shared between concurrent viewers, nil unless enabled
+	chunks *sharedChunks
+
+	// Where records' bytes come from, by source kind
+	sources map[string]FileSource
 }
 
 func New(cfg *config.Config, db *database.DB, storage *storage.Storage, domain string) *Server {
-	return &Server{
+	s := &Server{
 		db:      db,
 		storage: storage,
 		config:  cfg,
 		domain:  domain,
+
+		cookieKey: newCookieKey(cfg.Security.SigningSecret),
+		unlocks:   newUnlockLimiter(),
+		sessions:  newSessionTracker(),
+		shaper:    throttle.New(bandwidthLimits(cfg)),
+
+		connections:    newConnLimiter(cfg),
+		trustedProxies: parseTrustedProxies(cfg.Server.TrustedProxies),
+		accessLog:      openAccessLog(cfg),
+		certs:          newCertReloader(cfg.Server.TLS.CertFile, cfg.Server.TLS.KeyFile),
+		faststart:      newFaststartCache(),
+		chunks:         newSharedChunks(cfg),
 	}
+	s.sources = newFileSources(s)
+	return s
 }
 
-func (s *Server) Start() error {
+// defaultShutdownTimeout applies when the config doesn't set a drain timeout
+const defaultShutdownTimeout = 30 * time.Second
+
+// Start serves HTTP until ctx is cancelled, then stops accepting connections
+// and gives in-flight requests up to server.shutdown_timeout_seconds to finish
+func (s *Server) Start(ctx context.Context) error {
 	r := mux.NewRouter()
 
-	r.HandleFunc("/stream/{id}", s.handleStream).Methods("GET")
-	r.HandleFunc("/file/{id}", s.handleDownload).Methods("GET")
+	r.HandleFunc("/watch/{id}", s.handleWatch).Methods("GET")
+	r.HandleFunc("/stream/{id}", s.handleStream).Methods("GET", "HEAD")
+	r.HandleFunc("/file/{id}", s.handleDownload).Methods("GET", "HEAD")
+	r.HandleFunc("/subs/{id}/{lang}.vtt", s.handleSubtitle).Methods("GET")
+	r.HandleFunc("/api/files/{id}", s.handleFileInfo).Methods("GET")
+	r.HandleFunc("/playlist/{token:[A-Za-z0-9_-]+}.m3u8", s.handlePlaylist).Methods("GET")
+	r.HandleFunc("/playlist/{token:[A-Za-z0-9_-]+}.m3u", s.handlePlaylist).Methods("GET")
+	r.HandleFunc("/unlock/{id}", s.handleUnlock).Methods("POST")
 	r.HandleFunc("/health", s.handleHealth).Methods("GET")
 
+	// Metrics must be protected by a token or a separate bind address
+	var metricsServer *http.Server
+	if m := s.config.Metrics; m.Enabled {
+		switch {
+		case m.Listen != "":
+			metricsServer = metrics.Listen(m.Listen, m.Token)
+		case m.Token != "":
+			r.HandleFunc("/metrics", s.handleMetrics).Methods("GET")
+		default:
+			log.Println("Warning: metrics enabled without metrics.token or metrics.listen, not exposing /metrics")
+		}
+	}
+
 	port := s.config.Server.Port
 	if port == 0 {
 		port = 8080
@@ -53,8 +142,65 @@ func (s *Server) Start() error {
 	}
 
 	addr := fmt.Sprintf(":%d", port)
-	log.Printf("Starting HTTP server on %s", addr)
-	return http.ListenAndServe(addr, r)
+	httpServer := &http.Server{Addr: addr, Handler: s.logRequests(r)}
+
+	// Serve HTTPS directly when a certificate is configured
+	var redirectServer *http.Server
+	if s.certs != nil {
+		if err := s.certs.load(); err != nil {
+			return err
+		}
+		httpServer.TLSConfig = &tls.Config{
+			GetCertificate: s.certs.getCertificate,
+			MinVersion:     tls.VersionTLS12,
+		}
+		go s.certs.watch(ctx)
+
+		if tlsCfg := s.config.Server.TLS; tlsCfg.RedirectHTTP {
+			redirectServer = s.startRedirectListener(fmt.Sprintf(":%d", tlsCfg.HTTPPort), port)
+		}
+	}
+
+	errChan := make(chan error, 1)
+	go func() {
+		if s.certs != nil {
+			log.Printf("Starting HTTPS server on %s", addr)
+			errChan <- httpServer.ListenAndServeTLS("", "")
+			return
+		}
+		log.Printf("Starting HTTP server on %s", addr)
+		errChan <- httpServer.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errChan:
+		return err
+	case <-ctx.Done():
+	}
+
+	timeout := defaultShutdownTimeout
+	if seconds := s.config.Server.ShutdownTimeoutSeconds; seconds > 0 {
+		timeout = time.Duration(seconds) * time.Second
+	}
+	log.Printf("Stopping HTTP server, waiting up to %s for active requests", timeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if metricsServer != nil {
+		metricsServer.Shutdown(shutdownCtx)
+	}
+	if redirectServer != nil {
+		redirectServer.Shutdown(shutdownCtx)
+	}
+	err := httpServer.Shutdown(shutdownCtx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		log.Printf("Drain timeout reached, closing remaining connections")
+		err = httpServer.Close()
+	}
+	if s.accessLog != nil {
+		s.accessLog.Close()
+	}
+	return err
 }
 
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
@@ -62,10 +208,73 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("OK"))
 }
 
+// watchPage is the data passed to the watch.html template
+type watchPage struct {
+	Record      *database.FileRecord
+	Kind        string
+	StreamURL   string
+	DownloadURL string
+	Subtitles   []subtitleTrack
+}
+
+func (s *Server) handleWatch(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	fileID := vars["id"]
+
+	if !s.checkSignature(w, r, fileID) {
+		return
+	}
+
+	record, err := s.db.GetFileByID(fileID)
+	if err != nil {
+		log.Printf("File not found in database: %s, error: %v", fileID, err)
+		s.serveExpiredPage(w, r)
+		return
+	}
+
+	// Check if file is expired
+	if time.Now().After(record.ExpiresAt) {
+		s.serveExpiredPage(w, r)
+		return
+	}
+
+	// Check the file can still be read from its source
+	if !s.checkAvailable(w, r, record) {
+		return
+	}
+
+	if !s.checkPassword(w, r, record) {
+		return
+	}
+
+	// Nothing left to play for a client without a running session
+	if limitReached(record, sessionStream) && !s.sessions.active(s.sessionKey(r, record, sessionStream)) {
+		s.serveExhaustedPage(w, r)
+		return
+	}
+
+	ctx, cancel := probeContext(r)
+	s.ensureFileType(ctx, record)
+	s.ensureMediaInfo(ctx, record)
+	cancel()
+
+	s.renderTemplate(w, http.StatusOK, "watch.html", watchPage{
+		Record:      record,
+		Kind:        mediaKind(record.FileType),
+		StreamURL:   "/stream/" + record.ID + signedQuery(r),
+		DownloadURL: "/file/" + record.ID + signedQuery(r),
+		Subtitles:   s.subtitleTracks(r, record.ID),
+	})
+}
+
 func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	fileID := vars["id"]
 
+	if !s.checkSignature(w, r, fileID) {
+		return
+	}
+
 	record, err := s.db.GetFileByID(fileID)
 	if err != nil {
 		log.Printf("File not found in database: %s, error: %v", fileID, err)
@@ -89,14 +298,32 @@ func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if file exists (only for downloaded files, proxied files don't need this check)
-	if !record.IsProxied && !s.storage.FileExists(fileID, record.FileName) {
-		log.Printf("File not found on disk: %s/%s", fileID, record.FileName)
-		s.serveExpiredPage(w, r)
+	// Check the file can still be read from its source
+	if !s.checkAvailable(w, r, record) {
+		return
+	}
+
+	if !s.checkPassword(w, r, record) {
+		return
+	}
+
+	// Claim a connection slot before a new session is counted
+	done, ok := s.checkConnections(w, r, record.ID)
+	if !ok {
+		return
+	}
+	defer done()
+
+	if !s.checkLimit(w, r, record, sessionStream) {
 		return
 	}
 
 	log.Printf("Serving file: %s/%s", fileID, record.FileName)
+	w, finish := s.instrument(w, "stream", record)
+	defer finish()
+	w, release := s.throttle(w, r, record.ID, throttle.Stream)
+	defer release()
+
 	// Serve file with byte-range support
 	s.serveFileWithRange(w, r, record)
 }
@@ -105,6 +332,10 @@ func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	fileID := vars["id"]
 
+	if !s.checkSignature(w, r, fileID) {
+		return
+	}
+
 	record, err := s.db.GetFileByID(fileID)
 	if err != nil {
 		s.serveExpiredPage(w, r)
@@ -117,76 +348,104 @@ func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// If proxied, proxy from Telegram
-	if record.IsProxied && record.TelegramFileURL != "" {
-		log.Printf("Proxying download from Telegram: %s", record.TelegramFileURL)
-		s.proxyTelegramFile(w, r, record)
+	// Check the file can still be read from its source
+	if !s.checkAvailable(w, r, record) {
 		return
 	}
 
-	// Check if file exists (only for downloaded files)
-	if !record.IsProxied && !s.storage.FileExists(fileID, record.FileName) {
-		s.serveExpiredPage(w, r)
+	if !s.checkPassword(w, r, record) {
 		return
 	}
 
-	// Serve file for download
-	filePath := s.storage.GetFilePath(fileID, record.FileName)
-	file, err := os.Open(filePath)
-	if err != nil {
-		http.Error(w, "File not found", http.StatusNotFound)
+	// Claim a connection slot before a new session is counted
+	done, ok := s.checkConnections(w, r, record.ID)
+	if !ok {
 		return
 	}
-	defer file.Close()
+	defer done()
 
-	w.Header().Set("Content-Type", record.FileType)
+	if !s.checkLimit(w, r, record, sessionDownload) {
+		return
+	}
+
+	// Serve file for download - same range and validator handling as streaming,
+	// so download managers can resume
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", record.FileName))
-	w.Header().Set("Content-Length", strconv.FormatInt(record.FileSize, 10))
+	w, finish := s.instrument(w, "file", record)
+	defer finish()
+	w, release := s.throttle(w, r, record.ID, throttle.Download)
+	defer release()
 
-	io.Copy(w, file)
+	s.serveFileWithRange(w, r, record)
 }
 
 func (s *Server) serveFileWithRange(w http.ResponseWriter, r *http.Request, record *database.FileRecord) {
-	// If file is proxied from Telegram, proxy the request to Telegram
-	if record.IsProxied && record.TelegramFileURL != "" {
-		log.Printf("Proxying file from Telegram: %s", record.TelegramFileURL)
-		s.proxyTelegramFile(w, r, record)
-		return
-	}
-
-	// Otherwise, serve from local storage
-	filePath := s.storage.GetFilePath(record.ID, record.FileName)
-	file, err := os.Open(filePath)
+	file, size, err := s.openFile(r.Context(), record)
 	if err != nil {
+		log.Printf("Error opening %s from %s: %v", record.ID, record.SourceKind, err)
 		http.Error(w, "File not found", http.StatusNotFound)
 		return
 	}
 	defer file.Close()
 
-	fileInfo, err := file.Stat()
-	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
+	s.serveContent(w, r, record, file, size)
+}
+
+// serveContent answers GET/HEAD for a file of the given size, honouring
+// validators and byte ranges. Content is read through io.ReaderAt so every
+// FileSource shares the same code path.
+func (s *Server) serveContent(w http.ResponseWriter, r *http.Request, record *database.FileRecord, content io.ReaderAt, fileSize int64) {
+	etag := fileETag(record)
+	modified := record.UploadedAt
+
+	// Detect the real type of files stored as application/octet-stream
+	if sniff.IsGeneric(record.FileType) && fileSize > 0 {
+		head := make([]byte, min(int64(sniff.HeaderSize), fileSize))
+		if _, err := content.ReadAt(head, 0); err == nil || err == io.EOF {
+			s.correctFileType(record, head)
+		}
 	}
 
-	fileSize := fileInfo.Size()
+	// MP4s with the index at the end are served with it moved to the front
+	if layout := s.faststartLayout(record, content, fileSize); layout != nil {
+		content = layout.Reader(content)
+		fileSize = layout.Size()
+		etag = faststartETag(record, fileSize)
+		// Both layouts share the upload date, so a date-based If-Range could
+		// splice bytes of the uploaded file into the rewritten one
+		modified = time.Time{}
+	}
+
+	// Validators let players resume with If-Range and browsers revalidate
+	setValidators(w, etag, modified)
+	if checkNotModified(w, r, etag, modified) {
+		return
+	}
 
 	// Parse Range header
 	rangeHeader := r.Header.Get("Range")
-	if rangeHeader == "" {
-		// No range requested, serve entire file
+	if rangeHeader == "" || !rangeAllowed(r, etag, modified) {
+		// No range requested (or If-Range no longer matches), serve entire file
 		w.Header().Set("Content-Type", record.FileType)
 		w.Header().Set("Content-Length", strconv.FormatInt(fileSize, 10))
 		w.Header().Set("Accept-Ranges", "bytes")
 		w.WriteHeader(http.StatusOK)
-		io.Copy(w, file)
+		if r.Method != http.MethodHead {
+			copyRange(w, content, 0, fileSize)
+		}
 		return
 	}
 
 	// Parse range
 	ranges := parseRange(rangeHeader, fileSize)
 	if len(ranges) == 0 {
-		http.Error(w, "Invalid range", http.StatusRequestedRangeNotSatisfiable)
+		serveRangeNotSatisfiable(w, fileSize)
+		return
+	}
+
+	// Several ranges are sent back as multipart/byteranges
+	if len(ranges) > 1 {
+		serveMultipartRanges(w, r, content, ranges, record.FileType, fileSize)
 		return
 	}
 
@@ -197,68 +456,163 @@ func (s *Server) serveFileWithRange(w http.ResponseWriter, r *http.Request, reco
 	// Set headers for partial content
 	w.Header().Set("Content-Type", record.FileType)
 	w.Header().Set("Accept-Ranges", "bytes")
-	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, fileSize))
+	w.Header().Set("Content-Range", ranges[0].contentRange(fileSize))
 	w.Header().Set("Content-Length", strconv.FormatInt(end-start+1, 10))
 	w.WriteHeader(http.StatusPartialContent)
 
-	// Seek to start position
-	file.Seek(start, io.SeekStart)
+	if r.Method == http.MethodHead {
+		return
+	}
 
 	// Copy requested range
-	io.CopyN(w, file, end-start+1)
+	copyRange(w, content, start, end-start+1)
 }
 
-func (s *Server) proxyTelegramFile(w http.ResponseWriter, r *http.Request, record *database.FileRecord) {
-	telegramFileURL := record.TelegramFileURL
-	
-	// Check if this is a large file workaround (file_id: prefix)
-	if strings.HasPrefix(telegramFileURL, "file_id:") {
-		// Large file - GetFile API failed, we only have file_id
-		// Unfortunately, we can't construct the file URL without the file_path from GetFile
-		// For now, return an error explaining the limitation
-		log.Printf("Large file proxy requested but file_path unavailable (file_id: %s)", record.TelegramFileID)
-		http.Error(w, "Large files (>50MB) cannot be streamed through this bot due to Telegram API limitations. Please download directly from Telegram.", http.StatusNotImplemented)
+// copyRange writes length bytes of content starting at start. Plain files are
+// read directly rather than through a SectionReader. Responses are wrapped for
+// the access log, metrics and bandwidth shaper, so the bytes always pass
+// through their Write and sendfile isn't used.
+func copyRange(w io.Writer, content io.ReaderAt, start, length int64) (int64, error) {
+	if file, ok := content.(*os.File); ok {
+		if _, err := file.Seek(start, io.SeekStart); err != nil {
+			return 0, err
+		}
+		return io.CopyN(w, file, length)
+	}
+	return io.Copy(w, io.NewSectionReader(content, start, length))
+}
+
+// correctFileType replaces a generic stored MIME type with the one detected
+// from the file's first bytes and saves it for later requests
+func (s *Server) correctFileType(record *database.FileRecord, head []byte) {
+	if !sniff.IsGeneric(record.FileType) {
 		return
 	}
-	
-	// Create request to Telegram
-	req, err := http.NewRequest("GET", telegramFileURL, nil)
-	if err != nil {
-		http.Error(w, "Failed to create request", http.StatusInternalServerError)
+	detected := sniff.Detect(head)
+	if detected == "" {
 		return
 	}
 
-	// Forward Range header if present (for byte-range support)
-	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
-		req.Header.Set("Range", rangeHeader)
+	log.Printf("Detected content type %s for %s", detected, record.ID)
+	record.FileType = detected
+	if err := s.db.UpdateFileType(record.ID, detected); err != nil {
+		log.Printf("Error saving content type for %s: %v", record.ID, err)
 	}
+}
 
-	// Make request to Telegram
+// fetchUpstream requests a proxied file from Telegram, aborting the transfer
+// when ctx is cancelled. If the stored link has expired early (404/400) it is
+// resolved again and the request retried once.
+func (s *Server) fetchUpstream(ctx context.Context, record *database.FileRecord, method, rangeHeader string) (*http.Response, error) {
 	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := fetchTelegramFile(ctx, client, method, s.locator(record), rangeHeader)
 	if err != nil {
-		http.Error(w, "Failed to fetch file from Telegram", http.StatusBadGateway)
-		return
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusNotFound && resp.StatusCode != http.StatusBadRequest {
+		return resp, nil
 	}
-	defer resp.Body.Close()
 
-	// Copy headers from Telegram response
-	for key, values := range resp.Header {
-		for _, value := range values {
-			w.Header().Add(key, value)
-		}
+	resp.Body.Close()
+	log.Printf("Telegram returned %d for %s, refreshing file link", resp.StatusCode, record.ID)
+	fileURL, err := s.refreshTelegramURL(record)
+	if err != nil {
+		metrics.UpstreamErrors.Inc("refresh")
+		return nil, fmt.Errorf("failed to refresh file link: %w", err)
 	}
+	return fetchTelegramFile(ctx, client, method, fileURL, rangeHeader)
+}
 
-	// Set content type if not set
-	if resp.Header.Get("Content-Type") == "" {
-		w.Header().Set("Content-Type", record.FileType)
+// fetchTelegramFile requests a proxied file from Telegram, forwarding rangeHeader.
+// Latency and failures are recorded in the upstream metrics.
+func fetchTelegramFile(ctx context.Context, client *http.Client, method, fileURL, rangeHeader string) (*http.Response, error) {
+	start := time.Now()
+	resp, err := doFetchTelegramFile(ctx, client, method, fileURL, rangeHeader)
+	metrics.UpstreamDuration.Observe(time.Since(start).Seconds())
+
+	switch {
+	case err != nil:
+		metrics.UpstreamErrors.Inc("network")
+	case resp.StatusCode >= 500:
+		metrics.UpstreamErrors.Inc("http_5xx")
+	case resp.StatusCode >= 400 && resp.StatusCode != http.StatusRequestedRangeNotSatisfiable:
+		metrics.UpstreamErrors.Inc("http_4xx")
+	}
+	return resp, err
+}
+
+func doFetchTelegramFile(ctx context.Context, client *http.Client, method, fileURL, rangeHeader string) (*http.Response, error) {
+	if method == http.MethodHead {
+		// Only the headers are needed - don't stream the file through
+		return probeTelegramFile(ctx, client, fileURL, rangeHeader)
+	}
+
+	// Create request to Telegram
+	req, err := http.NewRequestWithContext(ctx, "GET", fileURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if rangeHeader != "" {
+		req.Header.Set("Range", rangeHeader)
 	}
 
-	// Copy status code
-	w.WriteHeader(resp.StatusCode)
+	// Make request to Telegram
+	return client.Do(req)
+}
 
-	// Stream response
-	io.Copy(w, resp.Body)
+// probeTelegramFile fetches only the headers of a proxied file. HEAD is tried
+// first; file servers that reject it get a zero-length ranged GET instead, and
+// the answer is rewritten to what a HEAD for rangeHeader would have returned.
+func probeTelegramFile(ctx context.Context, client *http.Client, fileURL, rangeHeader string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, fileURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if rangeHeader != "" {
+		req.Header.Set("Range", rangeHeader)
+	}
+	resp, err := client.Do(req)
+	if err == nil && resp.StatusCode != http.StatusMethodNotAllowed && resp.StatusCode != http.StatusNotImplemented {
+		return resp, nil
+	}
+	if err == nil {
+		resp.Body.Close()
+	}
+
+	// Fall back to the first byte to learn the total size
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	resp, err = client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	resp.Body = http.NoBody
+
+	size := totalFromContentRange(resp.Header.Get("Content-Range"))
+	if resp.StatusCode != http.StatusPartialContent || size < 0 {
+		// Upstream ignored the range - its headers already describe the full file
+		return resp, nil
+	}
+
+	resp.Header.Del("Content-Range")
+	ranges := parseRange(rangeHeader, size)
+	switch {
+	case rangeHeader == "" || len(ranges) > 1:
+		resp.StatusCode = http.StatusOK
+		resp.Header.Set("Content-Length", strconv.FormatInt(size, 10))
+	case len(ranges) == 1:
+		resp.Header.Set("Content-Range", ranges[0].contentRange(size))
+		resp.Header.Set("Content-Length", strconv.FormatInt(ranges[0].length(), 10))
+	default:
+		resp.StatusCode = http.StatusRequestedRangeNotSatisfiable
+		resp.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		resp.Header.Del("Content-Length")
+	}
+	return resp, nil
 }
 
 type byteRange struct {
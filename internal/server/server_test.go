@@ -0,0 +1,192 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"hafton-movie-bot/internal/config"
+	"hafton-movie-bot/internal/database"
+	"hafton-movie-bot/internal/storage"
+
+	"github.com/gorilla/mux"
+)
+
+// newTestServer returns a server backed by a temporary database and storage
+func newTestServer(t *testing.T, cfg *config.Config) *Server {
+	t.Helper()
+	dir := t.TempDir()
+	db, err := database.New(filepath.Join(dir, "files.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	st, err := storage.New(filepath.Join(dir, "storage"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(cfg, db, st, "example.com")
+}
+
+// addTestFile stores data on disk and inserts a record serving it
+func addTestFile(t *testing.T, s *Server, id string, data []byte) *database.FileRecord {
+	t.Helper()
+	record := &database.FileRecord{
+		ID:             id,
+		TelegramFileID: "tg-" + id,
+		SourceKind:     database.SourceStorage,
+		SourceLocator:  id + ".mp4",
+		FileName:       id + ".mp4",
+		FileSize:       int64(len(data)),
+		FileType:       "video/mp4",
+		UploadedAt:     time.Now().Add(-time.Hour).Truncate(time.Second),
+		ExpiresAt:      time.Now().Add(time.Hour),
+		TelegramUserID: 1,
+	}
+	if err := s.storage.SaveFile(id, record.FileName, data); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.db.InsertFile(record); err != nil {
+		t.Fatal(err)
+	}
+	return record
+}
+
+// serve runs a handler for a request to the file with the given id
+func serve(handler http.HandlerFunc, req *http.Request, id string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler(rec, mux.SetURLVars(req, map[string]string{"id": id}))
+	return rec
+}
+
+// addProxiedFile inserts a record proxied from fileURL
+func addProxiedFile(t *testing.T, s *Server, id, fileURL string, size int64) *database.FileRecord {
+	t.Helper()
+	record := &database.FileRecord{
+		ID:                   id,
+		TelegramFileID:       "tg-" + id,
+		SourceKind:           database.SourceTelegram,
+		SourceLocator:        fileURL,
+		FileName:             id + ".mp4",
+		FileSize:             size,
+		FileType:             "video/mp4",
+		UploadedAt:           time.Now().Add(-time.Hour).Truncate(time.Second),
+		ExpiresAt:            time.Now().Add(time.Hour),
+		TelegramUserID:       1,
+		TelegramURLUpdatedAt: time.Now(),
+	}
+	if err := s.db.InsertFile(record); err != nil {
+		t.Fatal(err)
+	}
+	return record
+}
+
+// testUpstream stands in for Telegram's file server
+type testUpstream struct {
+	*httptest.Server
+	content []byte
+
+	mu          sync.Mutex
+	ranges      []string      // Range header of every GET, in order
+	drops       []int64       // Bytes sent before dropping each GET, in order; -1 sends everything
+	ignoreRange bool          // Answer ranged requests with the whole file
+	delay       time.Duration // Wait before answering each GET
+	expiredPath string        // Answered with 404, like a link Telegram no longer honours
+	active      int           // GETs being answered
+	peak        int           // Most GETs answered at once
+}
+
+func newTestUpstream(t *testing.T, content []byte) *testUpstream {
+	u := &testUpstream{content: content}
+	u.Server = httptest.NewServer(http.HandlerFunc(u.serve))
+	t.Cleanup(u.Close)
+	return u
+}
+
+// fileURL is the link of the upstream file
+func (u *testUpstream) fileURL() string {
+	return u.URL + "/file/bot-token/videos/file.mp4"
+}
+
+// peakRequests returns the most GETs that were answered at once
+func (u *testUpstream) peakRequests() int {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.peak
+}
+
+// requests returns the Range headers of the GETs so far
+func (u *testUpstream) requests() []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return append([]string(nil), u.ranges...)
+}
+
+func (u *testUpstream) serve(w http.ResponseWriter, r *http.Request) {
+	size := int64(len(u.content))
+	u.mu.Lock()
+	drop := int64(-1)
+	if r.Method == http.MethodGet {
+		u.ranges = append(u.ranges, r.Header.Get("Range"))
+		if len(u.drops) > 0 {
+			drop, u.drops = u.drops[0], u.drops[1:]
+		}
+		u.active++
+		u.peak = max(u.peak, u.active)
+		defer func() {
+			u.mu.Lock()
+			u.active--
+			u.mu.Unlock()
+		}()
+	}
+	ignoreRange, delay, expiredPath := u.ignoreRange, u.delay, u.expiredPath
+	u.mu.Unlock()
+	time.Sleep(delay)
+
+	if expiredPath != "" && r.URL.Path == expiredPath {
+		http.NotFound(w, r)
+		return
+	}
+
+	body := u.content
+	ranges := parseRange(r.Header.Get("Range"), size)
+	switch {
+	case r.Header.Get("Range") == "" || ignoreRange:
+	case len(ranges) == 1:
+		body = u.content[ranges[0].start : ranges[0].end+1]
+		w.Header().Set("Content-Range", ranges[0].contentRange(size))
+	default:
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	if w.Header().Get("Content-Range") != "" {
+		w.WriteHeader(http.StatusPartialContent)
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	if drop >= 0 && drop < int64(len(body)) {
+		w.Write(body[:drop])
+		w.(http.Flusher).Flush()
+		// Break the connection mid-body
+		panic(http.ErrAbortHandler)
+	}
+	w.Write(body)
+}
+
+// testContent returns n bytes that differ at every offset within 251 bytes
+func testContent(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	return data
+}
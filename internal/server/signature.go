@@ -0,0 +1,63 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+
+	"hafton-movie-bot/internal/utils"
+)
+
+// messagePage is the data passed to the message.html template
+type messagePage struct {
+	Icon    string
+	Title   string
+	Message string
+}
+
+func (s *Server) serveForbiddenPage(w http.ResponseWriter, r *http.Request, message string) {
+	s.renderTemplate(w, http.StatusForbidden, "message.html", messagePage{
+		Icon:    "🔒",
+		Title:   "Access Denied",
+		Message: message,
+	})
+}
+
+// checkSignature enforces signed links when a signing secret is configured.
+// It writes a 403 page and returns false when the request must be rejected.
+func (s *Server) checkSignature(w http.ResponseWriter, r *http.Request, fileID string) bool {
+	secret := s.config.Security.SigningSecret
+	if secret == "" {
+		return true
+	}
+
+	err := utils.VerifySignature(secret, fileID, r.URL.Query())
+	if err == nil {
+		return true
+	}
+	if errors.Is(err, utils.ErrSignatureMissing) && s.config.Security.AllowUnsigned {
+		return true
+	}
+
+	log.Printf("Rejected request for %s: %v", fileID, err)
+	switch {
+	case errors.Is(err, utils.ErrSignatureMissing):
+		s.serveForbiddenPage(w, r, "This link is missing its signature. Use the full link sent by the bot.")
+	case errors.Is(err, utils.ErrSignatureExpired):
+		s.serveForbiddenPage(w, r, "This link has expired. Ask the bot for a new link.")
+	default:
+		s.serveForbiddenPage(w, r, "This link is invalid. Make sure you copied the full link sent by the bot.")
+	}
+	return false
+}
+
+// signedQuery returns the signature parameters of the request so they can be
+// carried over to links generated from it (e.g. the watch page's player)
+func signedQuery(r *http.Request) string {
+	query := r.URL.Query()
+	if query.Get("sig") == "" {
+		return ""
+	}
+	return "?" + url.Values{"e": {query.Get("e")}, "sig": {query.Get("sig")}}.Encode()
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+
+	"hafton-movie-bot/internal/database"
+	"hafton-movie-bot/internal/storage"
+)
+
+// FileSource is where a record's bytes come from. Handlers only read files
+// through the source registered for the record's kind, so a new backend needs
+// a FileSource and a kind but no changes to them.
+type FileSource interface {
+	// Stat returns the file's size, or an error if it can't be served
+	Stat(ctx context.Context, record *database.FileRecord) (int64, error)
+
+	// Open returns random access to the file's bytes. Transfers made for it
+	// are abandoned once ctx is done.
+	Open(ctx context.Context, record *database.FileRecord) (SourceFile, error)
+}
+
+// SourceFile is a file opened from a FileSource. A byte range is read by
+// wrapping it in an io.SectionReader.
+type SourceFile interface {
+	io.ReaderAt
+	io.Closer
+}
+
+func newFileSources(s *Server) map[string]FileSource {
+	return map[string]FileSource{
+		database.SourceStorage:  &storageSource{storage: s.storage},
+		database.SourceTelegram: &telegramSource{s: s},
+		database.SourceBotAPI:   &botAPISource{s: s},
+	}
+}
+
+// sourceError is a failure to read a file that hasn't expired. It is answered
+// with its status rather than the expired page.
+type sourceError struct {
+	status int
+	err    error
+}
+
+func (e *sourceError) Error() string { return e.err.Error() }
+func (e *sourceError) Unwrap() error { return e.err }
+
+// checkAvailable reports whether a record's file can be served, writing an
+// error page when it can't
+func (s *Server) checkAvailable(w http.ResponseWriter, r *http.Request, record *database.FileRecord) bool {
+	source, ok := s.sources[record.SourceKind]
+	if !ok {
+		log.Printf("File %s has unknown source %q", record.ID, record.SourceKind)
+		s.serveExpiredPage(w, r)
+		return false
+	}
+	if _, err := source.Stat(r.Context(), record); err != nil {
+		log.Printf("File %s is unavailable: %v", record.ID, err)
+		var srcErr *sourceError
+		if errors.As(err, &srcErr) {
+			http.Error(w, http.StatusText(srcErr.status), srcErr.status)
+			return false
+		}
+		s.serveExpiredPage(w, r)
+		return false
+	}
+	return true
+}
+
+// openFile opens a record's file through its source and returns its size
+func (s *Server) openFile(ctx context.Context, record *database.FileRecord) (SourceFile, int64, error) {
+	source, ok := s.sources[record.SourceKind]
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown source %q", record.SourceKind)
+	}
+
+	size, err := source.Stat(ctx, record)
+	if err != nil {
+		return nil, 0, err
+	}
+	file, err := source.Open(ctx, record)
+	if err != nil {
+		return nil, 0, err
+	}
+	return file, size, nil
+}
+
+// storageSource serves files saved in our own storage directory
+type storageSource struct {
+	storage *storage.Storage
+}
+
+func (src *storageSource) path(record *database.FileRecord) string {
+	return src.storage.GetFilePath(record.ID, record.SourceLocator)
+}
+
+func (src *storageSource) Stat(ctx context.Context, record *database.FileRecord) (int64, error) {
+	info, err := os.Stat(src.path(record))
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (src *storageSource) Open(ctx context.Context, record *database.FileRecord) (SourceFile, error) {
+	return os.Open(src.path(record))
+}
+
+// botAPISource serves files straight from the disk of a Bot API server
+// running with --local. A file the server no longer has is requested from
+// Telegram again, which makes the server download it anew. A file that
+// can't be found even so is a fault here or in the Bot API server, not an
+// expired link.
+type botAPISource struct {
+	s *Server
+}
+
+func (src *botAPISource) Stat(ctx context.Context, record *database.FileRecord) (int64, error) {
+	info, err := os.Stat(src.s.locator(record))
+	if errors.Is(err, fs.ErrNotExist) && src.s.config.Telegram.LocalMode {
+		filePath, refreshErr := src.s.refreshLocalPath(record)
+		if refreshErr != nil {
+			return 0, &sourceError{http.StatusBadGateway, fmt.Errorf("%w (refreshing: %v)", err, refreshErr)}
+		}
+		info, err = os.Stat(filePath)
+	}
+	if err != nil {
+		return 0, &sourceError{http.StatusNotFound, err}
+	}
+	return info.Size(), nil
+}
+
+func (src *botAPISource) Open(ctx context.Context, record *database.FileRecord) (SourceFile, error) {
+	return os.Open(src.s.locator(record))
+}
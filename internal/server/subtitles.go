@@ -0,0 +1,80 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"hafton-movie-bot/internal/subtitles"
+
+	"github.com/gorilla/mux"
+)
+
+// subtitleTrack is a <track> on the watch page
+type subtitleTrack struct {
+	Language string
+	URL      string
+}
+
+// subtitleTracks lists a file's subtitles for the watch page
+func (s *Server) subtitleTracks(r *http.Request, fileID string) []subtitleTrack {
+	subs, err := s.db.GetSubtitles(fileID)
+	if err != nil {
+		log.Printf("Error loading subtitles for %s: %v", fileID, err)
+		return nil
+	}
+
+	tracks := make([]subtitleTrack, 0, len(subs))
+	for _, sub := range subs {
+		tracks = append(tracks, subtitleTrack{
+			Language: sub.Language,
+			URL:      "/subs/" + fileID + "/" + sub.Language + ".vtt" + signedQuery(r),
+		})
+	}
+	return tracks
+}
+
+// handleSubtitle serves an attached subtitle converted to WebVTT
+func (s *Server) handleSubtitle(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	fileID := vars["id"]
+
+	if !s.checkSignature(w, r, fileID) {
+		return
+	}
+
+	record, err := s.db.GetFileByID(fileID)
+	if err != nil || time.Now().After(record.ExpiresAt) {
+		http.NotFound(w, r)
+		return
+	}
+
+	if !s.checkPassword(w, r, record) {
+		return
+	}
+
+	sub, err := s.db.GetSubtitle(fileID, vars["lang"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	data, err := os.ReadFile(s.storage.GetFilePath(fileID, sub.FileName))
+	if err != nil {
+		log.Printf("Error reading subtitle %s for %s: %v", sub.FileName, fileID, err)
+		http.NotFound(w, r)
+		return
+	}
+
+	vtt, err := subtitles.ToWebVTT(data, sub.Format)
+	if err != nil {
+		log.Printf("Error converting subtitle %s for %s: %v", sub.FileName, fileID, err)
+		http.Error(w, "Failed to convert subtitle", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+	w.Header().Set("Last-Modified", sub.CreatedAt.UTC().Format(http.TimeFormat))
+	w.Write(vtt)
+}
@@ -0,0 +1,270 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"hafton-movie-bot/internal/botapi"
+	"hafton-movie-bot/internal/database"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Telegram only guarantees file_path links for about an hour
+const defaultFileLinkTTL = 50 * time.Minute
+
+// telegramAPI returns the server's own Bot API client, creating it on first use
+// so the server still starts when Telegram is unreachable
+func (s *Server) telegramAPI() (*tgbotapi.BotAPI, error) {
+	s.apiMu.Lock()
+	defer s.apiMu.Unlock()
+
+	if s.api != nil {
+		return s.api, nil
+	}
+
+	api, err := botapi.New(s.config)
+	if err != nil {
+		return nil, err
+	}
+	s.api = api
+	return api, nil
+}
+
+// fileLinkTTL is how long a resolved Telegram file link is trusted
+func (s *Server) fileLinkTTL() time.Duration {
+	if minutes := s.config.Telegram.FileLinkTTLMinutes; minutes > 0 {
+		return time.Duration(minutes) * time.Minute
+	}
+	return defaultFileLinkTTL
+}
+
+// telegramURLStale reports whether the cached file link has outlived the TTL
+func (s *Server) telegramURLStale(record *database.FileRecord) bool {
+	s.locatorMu.RLock()
+	defer s.locatorMu.RUnlock()
+	return time.Since(record.TelegramURLUpdatedAt) > s.fileLinkTTL()
+}
+
+// locator returns the record's current source locator. Transfers read it
+// through here since a refresh may replace it at any time.
+func (s *Server) locator(record *database.FileRecord) string {
+	s.locatorMu.RLock()
+	defer s.locatorMu.RUnlock()
+	return record.SourceLocator
+}
+
+// setLocator replaces the record's source locator after a refresh
+func (s *Server) setLocator(record *database.FileRecord, locator string, updated time.Time) {
+	s.locatorMu.Lock()
+	defer s.locatorMu.Unlock()
+	record.SourceLocator = locator
+	record.TelegramURLUpdatedAt = updated
+}
+
+// refreshTelegramURL calls getFile again for the record's file_id, stores the
+// new link and updates the record in place. It returns the new link.
+func (s *Server) refreshTelegramURL(record *database.FileRecord) (string, error) {
+	if record.TelegramFileID == "" {
+		return "", fmt.Errorf("record %s has no Telegram file ID", record.ID)
+	}
+
+	api, err := s.telegramAPI()
+	if err != nil {
+		return "", fmt.Errorf("failed to create bot API: %w", err)
+	}
+
+	file, err := api.GetFile(tgbotapi.FileConfig{FileID: record.TelegramFileID})
+	if err != nil {
+		return "", fmt.Errorf("failed to get file info: %w", err)
+	}
+
+	now := time.Now()
+	fileURL := file.Link(api.Token)
+	if err := s.db.UpdateSourceLocator(record.ID, fileURL, now); err != nil {
+		// The new link is still usable for this request
+		log.Printf("Error saving refreshed file link for %s: %v", record.ID, err)
+	}
+
+	s.setLocator(record, fileURL, now)
+	log.Printf("Refreshed Telegram file link for %s", record.ID)
+	return fileURL, nil
+}
+
+// refreshLocalPath calls getFile again for a file that went missing from a
+// local Bot API server's disk, which makes it download the file anew. It
+// returns the file's new path.
+func (s *Server) refreshLocalPath(record *database.FileRecord) (string, error) {
+	if record.TelegramFileID == "" {
+		return "", fmt.Errorf("record %s has no Telegram file ID", record.ID)
+	}
+
+	api, err := s.telegramAPI()
+	if err != nil {
+		return "", fmt.Errorf("failed to create bot API: %w", err)
+	}
+
+	file, err := api.GetFile(tgbotapi.FileConfig{FileID: record.TelegramFileID})
+	if err != nil {
+		return "", fmt.Errorf("failed to get file info: %w", err)
+	}
+	filePath, ok := botapi.LocalFilePath(s.config, file.FilePath)
+	if !ok {
+		return "", fmt.Errorf("bot API returned %q instead of a local path", file.FilePath)
+	}
+
+	now := time.Now()
+	if err := s.db.UpdateSourceLocator(record.ID, filePath, now); err != nil {
+		log.Printf("Error saving file path for %s: %v", record.ID, err)
+	}
+	s.setLocator(record, filePath, now)
+	log.Printf("Refreshed local Bot API path for %s", record.ID)
+	return filePath, nil
+}
+
+// telegramSource proxies files from Telegram. The locator is the file's
+// download link, resolved again with getFile once it goes stale.
+type telegramSource struct {
+	s *Server
+}
+
+func (src *telegramSource) Stat(ctx context.Context, record *database.FileRecord) (int64, error) {
+	s := src.s
+
+	// Telegram file links only live for about an hour
+	if s.locator(record) == "" || s.telegramURLStale(record) {
+		if _, err := s.refreshTelegramURL(record); err != nil {
+			if s.locator(record) == "" {
+				return 0, fmt.Errorf("no download link: %w", err)
+			}
+			log.Printf("Error refreshing file link for %s: %v", record.ID, err)
+		}
+	}
+	if record.FileSize > 0 {
+		return record.FileSize, nil
+	}
+
+	// Telegram didn't report a size with the upload, ask for it
+	resp, err := s.fetchUpstreamRetrying(ctx, record, http.MethodHead, "")
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.ContentLength < 0 {
+		return 0, fmt.Errorf("telegram returned status %d without a size", resp.StatusCode)
+	}
+	record.FileSize = resp.ContentLength
+	return record.FileSize, nil
+}
+
+// Open reads through the disk cache and/or the shared buffer when enabled,
+// filling them over parallel connections if those are enabled too. Without
+// either it reads over parallel connections, and otherwise through a single
+// stream.
+func (src *telegramSource) Open(ctx context.Context, record *database.FileRecord) (SourceFile, error) {
+	s := src.s
+	onUpstream := func(status int) { setUpstreamStatus(ctx, status) }
+
+	if file := s.newCachedFile(ctx, record); file != nil {
+		file.onUpstream = onUpstream
+		return file, nil
+	}
+	if file := s.newParallelFile(ctx, record); file != nil {
+		file.onUpstream = onUpstream
+		return file, nil
+	}
+	return &streamFile{s: s, ctx: ctx, record: record, onUpstream: onUpstream}, nil
+}
+
+// streamFile reads a proxied file through one upstream response at a time.
+// Reads continuing where the last one ended share the response; any other
+// read opens a new one at its offset. Broken transfers are resumed.
+type streamFile struct {
+	s      *Server
+	ctx    context.Context
+	record *database.FileRecord
+
+	// Called with Telegram's status code whenever a response is opened
+	onUpstream func(status int)
+
+	mu   sync.Mutex
+	body io.ReadCloser // nil until the first read
+	pos  int64         // File offset of the next byte of body
+}
+
+func (f *streamFile) ReadAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	size := f.record.FileSize
+	if off >= size {
+		return 0, io.EOF
+	}
+	if f.body == nil || off != f.pos {
+		if err := f.open(off); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := io.ReadFull(f.body, p[:min(int64(len(p)), size-off)])
+	f.pos += int64(n)
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// open replaces the current response with one starting at off
+func (f *streamFile) open(off int64) error {
+	if f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+
+	resp, err := f.s.fetchUpstreamRetrying(f.ctx, f.record, http.MethodGet, fmt.Sprintf("bytes=%d-", off))
+	if err != nil {
+		return err
+	}
+	if f.onUpstream != nil {
+		f.onUpstream(resp.StatusCode)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		if start, _, ok := spanFromContentRange(resp.Header.Get("Content-Range")); !ok || start != off {
+			resp.Body.Close()
+			return fmt.Errorf("telegram answered bytes %d- with %q", off, resp.Header.Get("Content-Range"))
+		}
+	case http.StatusOK:
+	default:
+		resp.Body.Close()
+		return fmt.Errorf("telegram returned status %d", resp.StatusCode)
+	}
+
+	body := f.s.newResumingBody(f.ctx, f.record, resp)
+	if resp.StatusCode == http.StatusOK && off > 0 {
+		// Telegram ignored the range, skip ahead
+		if _, err := io.CopyN(io.Discard, body, off); err != nil {
+			body.Close()
+			return fmt.Errorf("failed to skip to byte %d: %w", off, err)
+		}
+	}
+	f.body = body
+	f.pos = off
+	return nil
+}
+
+func (f *streamFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.body == nil {
+		return nil
+	}
+	return f.body.Close()
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"hafton-movie-bot/internal/config"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// useTestBotAPI answers the server's getFile calls with filePath and sends
+// downloads from Telegram's file server to upstream instead
+func useTestBotAPI(t *testing.T, s *Server, upstream *testUpstream, filePath string) {
+	t.Helper()
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var result interface{} = tgbotapi.User{ID: 1, IsBot: true, UserName: "test_bot"}
+		if strings.HasSuffix(r.URL.Path, "/getFile") {
+			result = tgbotapi.File{FileID: r.FormValue("file_id"), FilePath: filePath}
+		}
+		raw, _ := json.Marshal(result)
+		json.NewEncoder(w).Encode(tgbotapi.APIResponse{Ok: true, Result: raw})
+	}))
+	t.Cleanup(api.Close)
+
+	bot, err := tgbotapi.NewBotAPIWithClient("token", api.URL+"/bot%s/%s", api.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.api = bot
+
+	// File links always point at api.telegram.org
+	target, _ := url.Parse(upstream.URL)
+	transport := http.DefaultTransport
+	http.DefaultTransport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if r.URL.Host == "api.telegram.org" {
+			r = r.Clone(r.Context())
+			r.URL.Scheme, r.URL.Host = target.Scheme, target.Host
+		}
+		return transport.RoundTrip(r)
+	})
+	t.Cleanup(func() { http.DefaultTransport = transport })
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestLinkRefreshDuringParallelFetch(t *testing.T) {
+	content := testContent(100000)
+	cfg := &config.Config{}
+	cfg.Parallel.Enabled = true
+	cfg.Parallel.Connections = 4
+	cfg.Parallel.ChunkSizeKB = 16
+	cfg.Upstream.RetryBackoffMS = 1
+	s := newTestServer(t, cfg)
+	upstream := newTestUpstream(t, content)
+	useTestBotAPI(t, s, upstream, "videos/new.mp4")
+	record := addProxiedFile(t, s, "abc", upstream.fileURL(), int64(len(content)))
+
+	file := s.newParallelFile(context.Background(), record)
+	defer file.Close()
+	data := make([]byte, len(content))
+	if _, err := file.ReadAt(data[:16384], 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// The link expires once the first chunk is in, so every connection
+	// fetching the rest finds it gone and refreshes it while the others are
+	// still reading it
+	upstream.mu.Lock()
+	upstream.expiredPath = "/file/bot-token/videos/file.mp4"
+	upstream.mu.Unlock()
+	if _, err := file.ReadAt(data[16384:], 16384); err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Error("read bytes differ from the file")
+	}
+
+	want := "https://api.telegram.org/file/bottoken/videos/new.mp4"
+	if locator := s.locator(record); locator != want {
+		t.Errorf("locator = %q, want %q", locator, want)
+	}
+	stored, err := s.db.GetFileByID(record.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.SourceLocator != want {
+		t.Errorf("stored locator = %q, want %q", stored.SourceLocator, want)
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"hafton-movie-bot/internal/mediainfo"
+)
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+var templates = template.Must(template.New("").Funcs(template.FuncMap{
+	"formatSize":     formatSize,
+	"formatDuration": mediainfo.FormatDuration,
+	"formatTime": func(t time.Time) string {
+		return t.Format("Jan 2, 2006 15:04 MST")
+	},
+}).ParseFS(templateFS, "templates/*.html"))
+
+// renderTemplate writes the named HTML template with the given status code
+func (s *Server) renderTemplate(w http.ResponseWriter, status int, name string, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := templates.ExecuteTemplate(w, name, data); err != nil {
+		log.Printf("Error rendering template %s: %v", name, err)
+	}
+}
+
+// formatSize converts a byte count into a human readable string
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.2f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// mediaKind returns "video", "audio" or "" depending on the MIME type
+func mediaKind(fileType string) string {
+	switch {
+	case strings.HasPrefix(fileType, "video/"):
+		return "video"
+	case strings.HasPrefix(fileType, "audio/"):
+		return "audio"
+	default:
+		return ""
+	}
+}
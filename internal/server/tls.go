@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// certCheckInterval is how often the certificate files are checked for changes
+const certCheckInterval = time.Minute
+
+// certReloader holds the current TLS certificate. New connections pick up a
+// reloaded certificate while existing ones keep theirs.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// newCertReloader returns nil when TLS isn't configured
+func newCertReloader(certFile, keyFile string) *certReloader {
+	if certFile == "" || keyFile == "" {
+		return nil
+	}
+	return &certReloader{certFile: certFile, keyFile: keyFile}
+}
+
+// load reads the certificate and key from disk
+func (c *certReloader) load() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	c.mu.Lock()
+	c.cert = &cert
+	c.modTime = c.filesModTime()
+	c.mu.Unlock()
+	return nil
+}
+
+// filesModTime returns the newest modification time of the cert and key
+func (c *certReloader) filesModTime() time.Time {
+	var newest time.Time
+	for _, path := range []string{c.certFile, c.keyFile} {
+		if info, err := os.Stat(path); err == nil && info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+	}
+	return newest
+}
+
+func (c *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// watch reloads the certificate whenever its files change (e.g. certbot
+// renewed it) until ctx is cancelled
+func (c *certReloader) watch(ctx context.Context) {
+	ticker := time.NewTicker(certCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.mu.RLock()
+			changed := c.filesModTime().After(c.modTime)
+			c.mu.RUnlock()
+			if !changed {
+				continue
+			}
+			if err := c.load(); err != nil {
+				log.Printf("Error reloading TLS certificate, keeping the old one: %v", err)
+				continue
+			}
+			log.Println("Reloaded TLS certificate after file change")
+		}
+	}
+}
+
+// ReloadTLS reloads the certificate and key files, e.g. on SIGHUP. The old
+// certificate stays in use if the new files can't be loaded.
+func (s *Server) ReloadTLS() {
+	if s.certs == nil {
+		return
+	}
+	if err := s.certs.load(); err != nil {
+		log.Printf("Error reloading TLS certificate, keeping the old one: %v", err)
+		return
+	}
+	log.Println("Reloaded TLS certificate")
+}
+
+// startRedirectListener answers plain HTTP on addr with a redirect to the
+// HTTPS port
+func (s *Server) startRedirectListener(addr string, httpsPort int) *http.Server {
+	redirectServer := &http.Server{
+		Addr: addr,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host := r.Host
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+			if httpsPort != 443 {
+				host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+			}
+			http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+		}),
+	}
+
+	go func() {
+		log.Printf("Redirecting HTTP on %s to HTTPS", addr)
+		if err := redirectServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("HTTP redirect server error: %v", err)
+		}
+	}()
+	return redirectServer
+}
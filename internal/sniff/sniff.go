@@ -0,0 +1,139 @@
+package sniff
+
+import (
+	"bytes"
+	"strings"
+)
+
+// HeaderSize is how many leading bytes Detect wants to see
+const HeaderSize = 512
+
+// Detect returns the MIME type matching a file's leading bytes, or "" when it
+// isn't recognised
+func Detect(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		// The EBML header names the document type right after the magic
+		if bytes.Contains(head[:min(len(head), 64)], []byte("webm")) {
+			return "video/webm"
+		}
+		return "video/x-matroska"
+	case len(head) >= 12 && string(head[4:8]) == "ftyp":
+		return ftypType(string(head[8:12]))
+	case isMPEGTS(head):
+		return "video/mp2t"
+	case bytes.HasPrefix(head, []byte("fLaC")):
+		return "audio/flac"
+	case bytes.HasPrefix(head, []byte("OggS")):
+		if bytes.Contains(head, []byte("theora")) {
+			return "video/ogg"
+		}
+		return "audio/ogg"
+	case bytes.HasPrefix(head, []byte("ID3")):
+		return "audio/mpeg"
+	case len(head) >= 2 && head[0] == 0xFF && head[1]&0xF6 == 0xF0:
+		// ADTS: MPEG sync word with layer bits 00
+		return "audio/aac"
+	case len(head) >= 2 && head[0] == 0xFF && head[1]&0xE0 == 0xE0 && head[1]&0x06 != 0:
+		return "audio/mpeg"
+	case bytes.HasPrefix(head, []byte("%PDF-")):
+		return "application/pdf"
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")):
+		return "application/zip"
+	case bytes.HasPrefix(head, []byte{0xFF, 0xD8, 0xFF}):
+		return "image/jpeg"
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")):
+		return "image/png"
+	case bytes.HasPrefix(head, []byte("GIF87a")), bytes.HasPrefix(head, []byte("GIF89a")):
+		return "image/gif"
+	case len(head) >= 12 && string(head[:4]) == "RIFF":
+		switch string(head[8:12]) {
+		case "WEBP":
+			return "image/webp"
+		case "AVI ":
+			return "video/x-msvideo"
+		case "WAVE":
+			return "audio/wav"
+		}
+	}
+	return ""
+}
+
+// ftypType maps an ISO BMFF major brand to a MIME type
+func ftypType(brand string) string {
+	switch brand {
+	case "qt  ":
+		return "video/quicktime"
+	case "M4A ", "M4B ":
+		return "audio/mp4"
+	case "heic", "heix", "mif1":
+		return "image/heic"
+	case "avif":
+		return "image/avif"
+	}
+	return "video/mp4"
+}
+
+// isMPEGTS checks for the 0x47 sync byte at the start of consecutive
+// 188-byte transport stream packets
+func isMPEGTS(head []byte) bool {
+	if len(head) < 189 || head[0] != 0x47 || head[188] != 0x47 {
+		return false
+	}
+	return len(head) < 377 || head[376] == 0x47
+}
+
+// IsGeneric reports whether a MIME type says nothing about the content
+func IsGeneric(mimeType string) bool {
+	mimeType, _, _ = strings.Cut(mimeType, ";")
+	switch strings.TrimSpace(strings.ToLower(mimeType)) {
+	case "", "application/octet-stream", "binary/octet-stream", "application/x-download",
+		"application/force-download", "application/unknown":
+		return true
+	}
+	return false
+}
+
+// Resolve picks the type to store for a file. The detected type wins over a
+// generic declared type, and over a declared media type from a different
+// container family (e.g. an MKV labelled video/mp4). Otherwise the declared
+// type is kept, as it may be more specific (a .docx is also a ZIP).
+func Resolve(declared, detected string) string {
+	if detected == "" {
+		return declared
+	}
+	if IsGeneric(declared) {
+		return detected
+	}
+	if isMedia(declared) && isMedia(detected) && family(declared) != family(detected) {
+		return detected
+	}
+	return declared
+}
+
+func isMedia(mimeType string) bool {
+	return strings.HasPrefix(mimeType, "video/") || strings.HasPrefix(mimeType, "audio/")
+}
+
+// families groups MIME types that name the same container
+var families = map[string]string{
+	"video/mp4": "mp4", "audio/mp4": "mp4", "video/quicktime": "mp4", "video/x-m4v": "mp4",
+	"audio/x-m4a": "mp4", "audio/m4a": "mp4", "video/3gpp": "mp4", "audio/3gpp": "mp4",
+	"video/x-matroska": "matroska", "audio/x-matroska": "matroska", "video/webm": "matroska", "audio/webm": "matroska",
+	"audio/ogg": "ogg", "video/ogg": "ogg", "audio/opus": "ogg", "audio/vorbis": "ogg",
+	"audio/mpeg": "mpeg", "audio/mp3": "mpeg", "audio/x-mpeg": "mpeg",
+	"audio/flac": "flac", "audio/x-flac": "flac",
+	"audio/aac": "aac", "audio/x-aac": "aac", "audio/aacp": "aac",
+	"audio/wav": "wav", "audio/x-wav": "wav", "audio/wave": "wav",
+	"video/mp2t": "mpegts", "video/vnd.dlna.mpeg-tts": "mpegts",
+	"video/x-msvideo": "avi", "video/avi": "avi",
+}
+
+func family(mimeType string) string {
+	mimeType, _, _ = strings.Cut(mimeType, ";")
+	mimeType = strings.ToLower(strings.TrimSpace(mimeType))
+	if f, ok := families[mimeType]; ok {
+		return f
+	}
+	return mimeType
+}
@@ -0,0 +1,130 @@
+package sniff
+
+import (
+	"bytes"
+	"testing"
+)
+
+// ebmlHeader builds an EBML header with the given DocType
+func ebmlHeader(docType string) []byte {
+	doc := append([]byte{0x42, 0x82, 0x80 | byte(len(docType))}, docType...)
+	head := []byte{0x1A, 0x45, 0xDF, 0xA3, 0x80 | byte(len(doc)+4), 0x42, 0x86, 0x81, 0x01}
+	return append(head, doc...)
+}
+
+// tsPackets returns n 188-byte transport stream packets
+func tsPackets(n int) []byte {
+	data := make([]byte, 188*n)
+	for i := 0; i < n; i++ {
+		data[i*188] = 0x47
+	}
+	return data
+}
+
+func TestDetect(t *testing.T) {
+	brokenTS := tsPackets(3)
+	brokenTS[376] = 0
+
+	tests := []struct {
+		name string
+		head []byte
+		want string
+	}{
+		{"matroska", ebmlHeader("matroska"), "video/x-matroska"},
+		{"webm", ebmlHeader("webm"), "video/webm"},
+		{"mp4", []byte("\x00\x00\x00\x18ftypisom\x00\x00\x02\x00"), "video/mp4"},
+		{"unknown ftyp brand", []byte("\x00\x00\x00\x18ftypxxxx"), "video/mp4"},
+		{"quicktime", []byte("\x00\x00\x00\x14ftypqt  "), "video/quicktime"},
+		{"m4a", []byte("\x00\x00\x00\x20ftypM4A "), "audio/mp4"},
+		{"heic", []byte("\x00\x00\x00\x18ftypheic"), "image/heic"},
+		{"avif", []byte("\x00\x00\x00\x18ftypavif"), "image/avif"},
+		{"truncated ftyp", []byte("\x00\x00\x00\x18ftyp"), ""},
+		{"mpeg-ts", tsPackets(3), "video/mp2t"},
+		{"mpeg-ts with two packets", tsPackets(2)[:189], "video/mp2t"},
+		{"mpeg-ts lost sync", brokenTS, ""},
+		{"single sync byte", tsPackets(1), ""},
+		{"flac", []byte("fLaC\x00\x00\x00\x22"), "audio/flac"},
+		{"ogg vorbis", []byte("OggS\x00\x02\x00\x00\x01vorbis"), "audio/ogg"},
+		{"ogg theora", []byte("OggS\x00\x02\x00\x00\x80theora"), "video/ogg"},
+		{"id3", []byte("ID3\x04\x00\x00"), "audio/mpeg"},
+		{"adts", []byte{0xFF, 0xF1, 0x50, 0x80}, "audio/aac"},
+		{"mp3 frame", []byte{0xFF, 0xFB, 0x90, 0x64}, "audio/mpeg"},
+		{"mpeg sync with reserved layer", []byte{0xFF, 0xE0, 0x00}, ""},
+		{"pdf", []byte("%PDF-1.7\n"), "application/pdf"},
+		{"zip", []byte("PK\x03\x04\x14\x00"), "application/zip"},
+		{"jpeg", []byte{0xFF, 0xD8, 0xFF, 0xE0}, "image/jpeg"},
+		{"png", []byte("\x89PNG\r\n\x1a\n\x00\x00"), "image/png"},
+		{"gif87a", []byte("GIF87a"), "image/gif"},
+		{"gif89a", []byte("GIF89a"), "image/gif"},
+		{"webp", []byte("RIFF\x00\x00\x00\x00WEBPVP8 "), "image/webp"},
+		{"avi", []byte("RIFF\x00\x00\x00\x00AVI LIST"), "video/x-msvideo"},
+		{"wav", []byte("RIFF\x00\x00\x00\x00WAVEfmt "), "audio/wav"},
+		{"other riff", []byte("RIFF\x00\x00\x00\x00CDXA"), ""},
+		{"text", []byte("Hello, world"), ""},
+		{"empty", nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Detect(tt.head); got != tt.want {
+				t.Errorf("Detect() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectWebMDocTypeOutsideHeader(t *testing.T) {
+	// Only the start of the EBML header is searched for the DocType
+	head := append(ebmlHeader("matroska"), bytes.Repeat([]byte{0}, 64)...)
+	head = append(head, "webm"...)
+	if got := Detect(head); got != "video/x-matroska" {
+		t.Errorf("Detect() = %q, want %q", got, "video/x-matroska")
+	}
+}
+
+func TestIsGeneric(t *testing.T) {
+	tests := []struct {
+		mimeType string
+		want     bool
+	}{
+		{"", true},
+		{"application/octet-stream", true},
+		{"Application/Octet-Stream; charset=binary", true},
+		{" binary/octet-stream ", true},
+		{"application/force-download", true},
+		{"video/mp4", false},
+		{"text/plain; charset=utf-8", false},
+	}
+
+	for _, tt := range tests {
+		if got := IsGeneric(tt.mimeType); got != tt.want {
+			t.Errorf("IsGeneric(%q) = %v, want %v", tt.mimeType, got, tt.want)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		declared, detected string
+		want               string
+	}{
+		{"video/mp4", "", "video/mp4"},
+		{"application/octet-stream", "video/x-matroska", "video/x-matroska"},
+		{"", "audio/flac", "audio/flac"},
+		{"video/mp4", "video/x-matroska", "video/x-matroska"},
+		{"audio/mpeg", "audio/aac", "audio/aac"},
+		{"video/x-m4v", "video/mp4", "video/x-m4v"},
+		{"video/webm", "video/x-matroska", "video/webm"},
+		{"audio/x-wav", "audio/wav", "audio/x-wav"},
+		{"video/mp4; codecs=avc1", "video/quicktime", "video/mp4; codecs=avc1"},
+		{"application/vnd.openxmlformats-officedocument.wordprocessingml.document", "application/zip",
+			"application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+		{"image/jpeg", "video/mp4", "image/jpeg"},
+	}
+
+	for _, tt := range tests {
+		if got := Resolve(tt.declared, tt.detected); got != tt.want {
+			t.Errorf("Resolve(%q, %q) = %q, want %q", tt.declared, tt.detected, got, tt.want)
+		}
+	}
+}
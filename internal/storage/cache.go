@@ -0,0 +1,292 @@
+package storage
+
+import (
+	"container/list"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Cache keeps fixed-size chunks of proxied files on local disk. Chunk i of a
+// file covers bytes [i*chunkSize, (i+1)*chunkSize). Chunks are evicted least
+// recently used first once the total exceeds maxBytes.
+type Cache struct {
+	dir       string // Holds chunks of chunkSize only, see NewCache
+	chunkSize int64
+	maxBytes  int64
+
+	mu     sync.Mutex
+	lru    *list.List // front = most recently used
+	chunks map[chunkKey]*list.Element
+	used   int64
+}
+
+type chunkKey struct {
+	fileID string
+	index  int64
+}
+
+type chunkEntry struct {
+	key  chunkKey
+	size int64
+}
+
+// NewCache opens (or creates) a chunk cache in dir and indexes the chunks
+// already on disk, oldest first. Chunks live in a subdirectory named after
+// the chunk size; chunks cut with any other size are deleted, since their
+// indexes would point at the wrong offsets.
+func NewCache(dir string, chunkSize, maxBytes int64) (*Cache, error) {
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("cache chunk size must be positive")
+	}
+	sizeDir := fmt.Sprintf("chunks-%d", chunkSize)
+	if err := removeStaleChunks(dir, sizeDir); err != nil {
+		return nil, fmt.Errorf("failed to clear old cache chunks: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, sizeDir), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	c := &Cache{
+		dir:       filepath.Join(dir, sizeDir),
+		chunkSize: chunkSize,
+		maxBytes:  maxBytes,
+		lru:       list.New(),
+		chunks:    make(map[chunkKey]*list.Element),
+	}
+	if err := c.loadIndex(); err != nil {
+		return nil, fmt.Errorf("failed to index cache: %w", err)
+	}
+	return c, nil
+}
+
+// removeStaleChunks deletes everything in dir except keep: chunks of other
+// chunk sizes, and per-file directories from before chunks were kept by size
+func removeStaleChunks(dir, keep string) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Name() == keep {
+			continue
+		}
+		log.Printf("Removing cache chunks of another chunk size: %s", entry.Name())
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnableCache attaches a chunk cache stored under the storage base path
+func (s *Storage) EnableCache(chunkSize, maxBytes int64) error {
+	cache, err := NewCache(filepath.Join(s.basePath, ".cache"), chunkSize, maxBytes)
+	if err != nil {
+		return err
+	}
+	s.cache = cache
+	return nil
+}
+
+// Cache returns the chunk cache, or nil when caching is disabled
+func (s *Storage) Cache() *Cache {
+	return s.cache
+}
+
+// ChunkSize returns the size of every chunk except possibly a file's last one
+func (c *Cache) ChunkSize() int64 {
+	return c.chunkSize
+}
+
+func (c *Cache) chunkPath(key chunkKey) string {
+	return filepath.Join(c.dir, key.fileID, strconv.FormatInt(key.index, 10)+".chunk")
+}
+
+// Has reports whether a chunk is present
+func (c *Cache) Has(fileID string, index int64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.chunks[chunkKey{fileID, index}]
+	return ok
+}
+
+// ReadChunk returns a cached chunk and marks it as recently used
+func (c *Cache) ReadChunk(fileID string, index int64) ([]byte, bool) {
+	key := chunkKey{fileID, index}
+
+	c.mu.Lock()
+	elem, ok := c.chunks[key]
+	if ok {
+		c.lru.MoveToFront(elem)
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	data, err := os.ReadFile(c.chunkPath(key))
+	if err != nil {
+		// Chunk vanished from disk - forget it
+		log.Printf("Error reading cache chunk %s/%d: %v", fileID, index, err)
+		c.mu.Lock()
+		c.removeLocked(key)
+		c.mu.Unlock()
+		return nil, false
+	}
+	return data, true
+}
+
+// WriteChunk stores a chunk and evicts old chunks to stay within budget
+func (c *Cache) WriteChunk(fileID string, index int64, data []byte) error {
+	key := chunkKey{fileID, index}
+	size := int64(len(data))
+	if c.maxBytes > 0 && size > c.maxBytes {
+		return nil
+	}
+
+	dir := filepath.Join(c.dir, fileID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	// Write to a temp file and rename so readers never see a partial chunk
+	tmp, err := os.CreateTemp(dir, "chunk-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create cache chunk: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write cache chunk: %w", err)
+	}
+	tmp.Close()
+	if err := os.Rename(tmp.Name(), c.chunkPath(key)); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to store cache chunk: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.chunks[key]; ok {
+		// Another request filled the same chunk
+		c.used -= elem.Value.(*chunkEntry).size
+		elem.Value.(*chunkEntry).size = size
+		c.lru.MoveToFront(elem)
+	} else {
+		c.chunks[key] = c.lru.PushFront(&chunkEntry{key: key, size: size})
+	}
+	c.used += size
+	c.evictLocked()
+	return nil
+}
+
+// DeleteFile drops every cached chunk of a file
+func (c *Cache) DeleteFile(fileID string) error {
+	c.mu.Lock()
+	for key := range c.chunks {
+		if key.fileID == fileID {
+			c.removeLocked(key)
+		}
+	}
+	c.mu.Unlock()
+	return os.RemoveAll(filepath.Join(c.dir, fileID))
+}
+
+// Used returns the number of bytes currently cached
+func (c *Cache) Used() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.used
+}
+
+func (c *Cache) removeLocked(key chunkKey) {
+	elem, ok := c.chunks[key]
+	if !ok {
+		return
+	}
+	c.lru.Remove(elem)
+	delete(c.chunks, key)
+	c.used -= elem.Value.(*chunkEntry).size
+}
+
+func (c *Cache) evictLocked() {
+	if c.maxBytes <= 0 {
+		return
+	}
+	for c.used > c.maxBytes {
+		elem := c.lru.Back()
+		if elem == nil {
+			return
+		}
+		key := elem.Value.(*chunkEntry).key
+		c.removeLocked(key)
+		if err := os.Remove(c.chunkPath(key)); err != nil && !os.IsNotExist(err) {
+			log.Printf("Error evicting cache chunk %s/%d: %v", key.fileID, key.index, err)
+		}
+		// Try to remove the directory if empty
+		os.Remove(filepath.Join(c.dir, key.fileID))
+	}
+}
+
+// loadIndex rebuilds the in-memory index from the chunks on disk so the cache
+// survives restarts. Modification time stands in for last use.
+func (c *Cache) loadIndex() error {
+	type found struct {
+		entry   *chunkEntry
+		modTime int64
+	}
+	var chunks []found
+
+	fileDirs, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	for _, fileDir := range fileDirs {
+		if !fileDir.IsDir() {
+			continue
+		}
+		entries, err := os.ReadDir(filepath.Join(c.dir, fileDir.Name()))
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			path := filepath.Join(c.dir, fileDir.Name(), name)
+			if strings.HasSuffix(name, ".tmp") {
+				// Leftover from an interrupted write
+				os.Remove(path)
+				continue
+			}
+			index, err := strconv.ParseInt(strings.TrimSuffix(name, ".chunk"), 10, 64)
+			if err != nil || !strings.HasSuffix(name, ".chunk") {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			chunks = append(chunks, found{
+				entry:   &chunkEntry{key: chunkKey{fileDir.Name(), index}, size: info.Size()},
+				modTime: info.ModTime().UnixNano(),
+			})
+		}
+	}
+
+	// Oldest at the back of the list
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].modTime > chunks[j].modTime })
+	for _, chunk := range chunks {
+		c.chunks[chunk.entry.key] = c.lru.PushBack(chunk.entry)
+		c.used += chunk.entry.size
+	}
+	c.evictLocked()
+	return nil
+}
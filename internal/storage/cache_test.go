@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c, err := NewCache(t.TempDir(), 4, 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(0); i < 3; i++ {
+		if err := c.WriteChunk("f", i, []byte("abcd")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Reading chunk 0 makes chunk 1 the least recently used
+	if _, ok := c.ReadChunk("f", 0); !ok {
+		t.Fatal("chunk 0 missing")
+	}
+	if err := c.WriteChunk("f", 3, []byte("abcd")); err != nil {
+		t.Fatal(err)
+	}
+
+	for index, want := range []bool{true, false, true, true} {
+		if got := c.Has("f", int64(index)); got != want {
+			t.Errorf("Has(f, %d) = %v, want %v", index, got, want)
+		}
+	}
+	if c.Used() != 12 {
+		t.Errorf("Used() = %d, want 12", c.Used())
+	}
+	if _, err := os.Stat(c.chunkPath(chunkKey{"f", 1})); !os.IsNotExist(err) {
+		t.Errorf("evicted chunk still on disk: %v", err)
+	}
+}
+
+func TestCacheSkipsChunksOverBudget(t *testing.T) {
+	c, err := NewCache(t.TempDir(), 16, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WriteChunk("f", 0, make([]byte, 16)); err != nil {
+		t.Fatal(err)
+	}
+	if c.Has("f", 0) || c.Used() != 0 {
+		t.Errorf("chunk larger than the budget was cached, used %d", c.Used())
+	}
+}
+
+func TestCacheReindexesOnOpen(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewCache(dir, 4, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := int64(0); i < 3; i++ {
+		if err := c.WriteChunk("f", i, []byte("abcd")); err != nil {
+			t.Fatal(err)
+		}
+		// Modification time stands in for last use after a restart
+		at := time.Now().Add(time.Duration(i-10) * time.Minute)
+		if err := os.Chtimes(c.chunkPath(chunkKey{"f", i}), at, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	leftover := filepath.Join(c.dir, "f", "chunk-1.tmp")
+	if err := os.WriteFile(leftover, []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopening with a smaller budget drops the oldest chunk
+	c, err = NewCache(dir, 4, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for index, want := range []bool{false, true, true} {
+		if got := c.Has("f", int64(index)); got != want {
+			t.Errorf("Has(f, %d) = %v, want %v", index, got, want)
+		}
+	}
+	if c.Used() != 8 {
+		t.Errorf("Used() = %d, want 8", c.Used())
+	}
+	if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+		t.Errorf("interrupted write was not removed: %v", err)
+	}
+	if data, ok := c.ReadChunk("f", 2); !ok || string(data) != "abcd" {
+		t.Errorf("ReadChunk(f, 2) = %q, %v", data, ok)
+	}
+}
+
+func TestCacheDeleteFile(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewCache(dir, 4, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.WriteChunk("a", 0, []byte("abcd"))
+	c.WriteChunk("a", 1, []byte("ab"))
+	c.WriteChunk("b", 0, []byte("abcd"))
+
+	if err := c.DeleteFile("a"); err != nil {
+		t.Fatal(err)
+	}
+	if c.Has("a", 0) || c.Has("a", 1) || !c.Has("b", 0) {
+		t.Error("DeleteFile removed the wrong chunks")
+	}
+	if c.Used() != 4 {
+		t.Errorf("Used() = %d, want 4", c.Used())
+	}
+	if _, err := os.Stat(filepath.Join(c.dir, "a")); !os.IsNotExist(err) {
+		t.Errorf("file directory still present: %v", err)
+	}
+}
+
+func TestCacheDropsChunksOfAnotherSize(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewCache(dir, 4, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.WriteChunk("f", 1, []byte("abcd")); err != nil {
+		t.Fatal(err)
+	}
+	// A per-file directory from before chunks were kept by size
+	if err := os.MkdirAll(filepath.Join(dir, "old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "old", "0.chunk"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same size keeps its chunks
+	c, err = NewCache(dir, 4, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Has("f", 1) || c.Has("old", 0) {
+		t.Errorf("reopened with the same size: Has(f, 1) = %v, Has(old, 0) = %v", c.Has("f", 1), c.Has("old", 0))
+	}
+
+	// Another size must not read chunk 1 at the old offsets
+	c, err = NewCache(dir, 8, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Has("f", 1) || c.Used() != 0 {
+		t.Errorf("chunks of the old size survived, %d bytes used", c.Used())
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "chunks-8" {
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		t.Errorf("cache directory holds %v, want only chunks-8", names)
+	}
+}
@@ -8,6 +8,7 @@ import (
 
 type Storage struct {
 	basePath string
+	cache    *Cache // Chunk cache for proxied files, nil when disabled
 }
 
 func New(basePath string) (*Storage, error) {
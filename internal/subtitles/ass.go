@@ -0,0 +1,105 @@
+package subtitles
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// overrideTags matches ASS styling blocks such as {\i1} or {\pos(10,20)}
+var overrideTags = regexp.MustCompile(`\{[^}]*\}`)
+
+// convertASS turns the Dialogue lines of an (Advanced) SubStation Alpha file
+// into WebVTT cues. Styling and positioning are dropped.
+func convertASS(text string) ([]byte, error) {
+	var cues []cue
+	var format []string
+	inEvents := false
+
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			inEvents = strings.EqualFold(line, "[Events]")
+			continue
+		}
+		if !inEvents {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "Format":
+			format = strings.Split(value, ",")
+			for i := range format {
+				format[i] = strings.TrimSpace(format[i])
+			}
+		case "Dialogue":
+			if format == nil {
+				return nil, fmt.Errorf("dialogue before format line in [Events]")
+			}
+			// Text is last and may itself contain commas
+			fields := strings.SplitN(value, ",", len(format))
+			if len(fields) < len(format) {
+				continue
+			}
+
+			var c cue
+			for i, name := range format {
+				switch name {
+				case "Start":
+					c.start = assTimestamp(fields[i])
+				case "End":
+					c.end = assTimestamp(fields[i])
+				case "Text":
+					c.text = assText(fields[i])
+				}
+			}
+			if c.start == "" || c.end == "" || c.text == "" {
+				continue
+			}
+			cues = append(cues, c)
+		}
+	}
+
+	if format == nil {
+		return nil, fmt.Errorf("no [Events] section found")
+	}
+	return writeVTT(cues), nil
+}
+
+// assTimestamp converts "0:01:02.34" (centiseconds) to "00:01:02.340"
+func assTimestamp(value string) string {
+	parts := strings.Split(strings.TrimSpace(value), ":")
+	if len(parts) != 3 {
+		return ""
+	}
+	hours, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return ""
+	}
+	minutes, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return ""
+	}
+	seconds, err := strconv.ParseFloat(parts[2], 64)
+	if err != nil {
+		return ""
+	}
+	millis := int(seconds*1000 + 0.5)
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", hours, minutes, millis/1000, millis%1000)
+}
+
+// assText strips override tags and converts ASS line breaks
+func assText(value string) string {
+	value = overrideTags.ReplaceAllString(value, "")
+	value = strings.ReplaceAll(value, `\N`, "\n")
+	value = strings.ReplaceAll(value, `\n`, "\n")
+	value = strings.ReplaceAll(value, `\h`, " ")
+	return strings.TrimSpace(value)
+}
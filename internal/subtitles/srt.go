@@ -0,0 +1,63 @@
+package subtitles
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// srtTiming matches a cue's timing line, "00:01:02,345 --> 00:01:04,567".
+// Some files omit the hours or use a dot before the milliseconds, and
+// position settings may follow the end time.
+var srtTiming = regexp.MustCompile(`^\s*((?:\d+:)?\d{2}:\d{2}[,.]\d{1,3})\s*-->\s*((?:\d+:)?\d{2}:\d{2}[,.]\d{1,3})(?:\s.*)?$`)
+
+// convertSRT turns SubRip cues into WebVTT. SRT only differs in the comma
+// before the milliseconds and the numeric cue counters.
+func convertSRT(text string) ([]byte, error) {
+	var cues []cue
+	for _, block := range strings.Split(text, "\n\n") {
+		lines := strings.Split(strings.Trim(block, "\n"), "\n")
+
+		// Find the timing line; it's usually preceded by the cue number
+		timing := -1
+		var match []string
+		for i, line := range lines {
+			if match = srtTiming.FindStringSubmatch(line); match != nil {
+				timing = i
+				break
+			}
+		}
+		if timing < 0 {
+			continue
+		}
+
+		cues = append(cues, cue{
+			start: srtTimestamp(match[1]),
+			end:   srtTimestamp(match[2]),
+			// Players that don't know SSA tags like {\an8} in SRT would show them
+			text: overrideTags.ReplaceAllString(strings.Join(lines[timing+1:], "\n"), ""),
+		})
+	}
+
+	if len(cues) == 0 {
+		return nil, fmt.Errorf("no SubRip cues found")
+	}
+	return writeVTT(cues), nil
+}
+
+// srtTimestamp converts "00:01:02,345" to "00:01:02.345"
+func srtTimestamp(value string) string {
+	value = strings.TrimSpace(value)
+	value = strings.Replace(value, ",", ".", 1)
+	// Some files omit the hours
+	if strings.Count(value, ":") == 1 {
+		value = "00:" + value
+	} else if strings.IndexByte(value, ':') == 1 {
+		value = "0" + value
+	}
+	// WebVTT wants exactly three digits of milliseconds
+	if dot := strings.LastIndexByte(value, '.'); dot >= 0 && len(value)-dot-1 < 3 {
+		value += strings.Repeat("0", 3-(len(value)-dot-1))
+	}
+	return value
+}
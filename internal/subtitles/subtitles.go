@@ -0,0 +1,95 @@
+package subtitles
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// Supported subtitle formats
+const (
+	FormatSRT = "srt"
+	FormatASS = "ass"
+	FormatVTT = "vtt"
+)
+
+// DetectFormat returns the subtitle format for a file name, or "" if it
+// isn't a subtitle file
+func DetectFormat(fileName string) string {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".srt":
+		return FormatSRT
+	case ".ass", ".ssa":
+		return FormatASS
+	case ".vtt":
+		return FormatVTT
+	}
+	return ""
+}
+
+// ToWebVTT converts a subtitle file to WebVTT
+func ToWebVTT(data []byte, format string) ([]byte, error) {
+	text := normalize(data)
+
+	switch format {
+	case FormatSRT:
+		return convertSRT(text)
+	case FormatASS:
+		return convertASS(text)
+	case FormatVTT:
+		if !strings.HasPrefix(text, "WEBVTT") {
+			text = "WEBVTT\n\n" + text
+		}
+		return []byte(text), nil
+	}
+	return nil, fmt.Errorf("unsupported subtitle format %q", format)
+}
+
+// normalize strips a UTF-8 BOM, decodes Windows-1252 files and unifies line endings
+func normalize(data []byte) string {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	var text string
+	if utf8.Valid(data) {
+		text = string(data)
+	} else {
+		// Most non-UTF-8 subtitles in the wild are Windows-1252
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+			if b >= 0x80 && b < 0xa0 {
+				runes[i] = windows1252[b-0x80]
+			}
+		}
+		text = string(runes)
+	}
+
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	return strings.ReplaceAll(text, "\r", "\n")
+}
+
+// windows1252 maps bytes 0x80-0x9F, where Windows-1252 differs from Latin-1,
+// to runes. The five bytes it leaves undefined keep their Latin-1 meaning.
+var windows1252 = [32]rune{
+	0x20AC, 0x0081, 0x201A, 0x0192, 0x201E, 0x2026, 0x2020, 0x2021,
+	0x02C6, 0x2030, 0x0160, 0x2039, 0x0152, 0x008D, 0x017D, 0x008F,
+	0x0090, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014,
+	0x02DC, 0x2122, 0x0161, 0x203A, 0x0153, 0x009D, 0x017E, 0x0178,
+}
+
+// cue is one timed subtitle
+type cue struct {
+	start, end string // WebVTT timestamps
+	text       string
+}
+
+func writeVTT(cues []cue) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("WEBVTT\n\n")
+	for _, c := range cues {
+		fmt.Fprintf(&buf, "%s --> %s\n%s\n\n", c.start, c.end, c.text)
+	}
+	return buf.Bytes()
+}
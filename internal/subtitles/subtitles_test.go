@@ -0,0 +1,144 @@
+package subtitles
+
+import "testing"
+
+func TestToWebVTT(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		input  string
+		want   string
+	}{
+		{
+			name:   "srt",
+			format: FormatSRT,
+			input:  "1\n00:00:01,000 --> 00:00:02,500\nHello\n\n2\n00:00:03,000 --> 00:00:04,000\nTwo\nlines\n",
+			want:   "WEBVTT\n\n00:00:01.000 --> 00:00:02.500\nHello\n\n00:00:03.000 --> 00:00:04.000\nTwo\nlines\n\n",
+		},
+		{
+			name:   "srt with BOM and CRLF",
+			format: FormatSRT,
+			input:  "\xef\xbb\xbf1\r\n00:00:01,000 --> 00:00:02,000\r\nHello\r\n\r\n2\r\n00:00:03,000 --> 00:00:04,000\r\nWorld\r\n",
+			want:   "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nHello\n\n00:00:03.000 --> 00:00:04.000\nWorld\n\n",
+		},
+		{
+			name:   "srt with old Mac line endings",
+			format: FormatSRT,
+			input:  "1\r00:00:01,000 --> 00:00:02,000\rHello\r",
+			want:   "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nHello\n\n",
+		},
+		{
+			name:   "srt without hours and with short milliseconds",
+			format: FormatSRT,
+			input:  "1\n01:02,5 --> 0:01:03,25\nHi\n",
+			want:   "WEBVTT\n\n00:01:02.500 --> 00:01:03.250\nHi\n\n",
+		},
+		{
+			name:   "srt with position and override tags",
+			format: FormatSRT,
+			input:  "1\n00:00:01,000 --> 00:00:02,000 X1:10 X2:20 Y1:30 Y2:40\n{\\an8}Top\n",
+			want:   "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nTop\n\n",
+		},
+		{
+			name:   "srt skips blocks without a valid timing line",
+			format: FormatSRT,
+			input:  "1\nnot --> a time\nJunk\n\n2\n00:00:03,000 --> 00:00:04,000\nKept\n",
+			want:   "WEBVTT\n\n00:00:03.000 --> 00:00:04.000\nKept\n\n",
+		},
+		{
+			name:   "srt in Windows-1252",
+			format: FormatSRT,
+			input:  "1\n00:00:01,000 --> 00:00:02,000\nCaf\xe9 \x93quoted\x94 \x80\x85\n",
+			want:   "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nCafé “quoted” €…\n\n",
+		},
+		{
+			name:   "ass",
+			format: FormatASS,
+			input: "[Script Info]\nTitle: Test\n\n[V4+ Styles]\nFormat: Name, Fontname\nStyle: Default,Arial\n\n[Events]\n" +
+				"Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\n" +
+				"Dialogue: 0,0:00:01.00,0:00:02.50,Default,,0,0,0,,{\\i1}Hello{\\i0}, world\\Nagain\n" +
+				"Comment: 0,0:00:03.00,0:00:04.00,Default,,0,0,0,,Hidden\n",
+			want: "WEBVTT\n\n00:00:01.000 --> 00:00:02.500\nHello, world\nagain\n\n",
+		},
+		{
+			name:   "ass with reordered format",
+			format: FormatASS,
+			input:  "[Events]\nFormat: End, Style, Start, Text\nDialogue: 0:01:05.25,Default,0:01:02.10,Late, but fine\n",
+			want:   "WEBVTT\n\n00:01:02.100 --> 00:01:05.250\nLate, but fine\n\n",
+		},
+		{
+			name:   "ass skips dialogue with bad times or no text",
+			format: FormatASS,
+			input:  "[Events]\nFormat: Start, End, Text\nDialogue: soon,0:00:02.00,Bad\nDialogue: 0:00:01.00,0:00:02.00,{\\b1}\nDialogue: 0:00:03.00,0:00:04.00,Kept\\hhere\n",
+			want:   "WEBVTT\n\n00:00:03.000 --> 00:00:04.000\nKept here\n\n",
+		},
+		{
+			name:   "vtt gets a header",
+			format: FormatVTT,
+			input:  "00:00:01.000 --> 00:00:02.000\nHello\n",
+			want:   "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nHello\n",
+		},
+		{
+			name:   "vtt kept as is",
+			format: FormatVTT,
+			input:  "WEBVTT\r\n\r\n00:00:01.000 --> 00:00:02.000\r\nHello\r\n",
+			want:   "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nHello\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ToWebVTT([]byte(tt.input), tt.format)
+			if err != nil {
+				t.Fatalf("ToWebVTT() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("ToWebVTT() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToWebVTTErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		input  string
+	}{
+		{"srt without cues", FormatSRT, "Just some text\n\nand more text\n"},
+		{"srt with malformed timing", FormatSRT, "1\n00:00:01 --> 00:00:02\nNo milliseconds\n"},
+		{"empty srt", FormatSRT, ""},
+		{"ass without events", FormatASS, "[Script Info]\nTitle: Test\n"},
+		{"ass dialogue before format", FormatASS, "[Events]\nDialogue: 0,0:00:01.00,0:00:02.00,Default,,0,0,0,,Hi\n"},
+		{"unknown format", "sub", "{1}{25}Hello\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := ToWebVTT([]byte(tt.input), tt.format); err == nil {
+				t.Errorf("ToWebVTT() = %q, want an error", got)
+			}
+		})
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		fileName string
+		want     string
+	}{
+		{"movie.srt", FormatSRT},
+		{"Movie.EN.SRT", FormatSRT},
+		{"movie.ass", FormatASS},
+		{"movie.ssa", FormatASS},
+		{"movie.vtt", FormatVTT},
+		{"movie.mkv", ""},
+		{"srt", ""},
+	}
+
+	for _, tt := range tests {
+		if got := DetectFormat(tt.fileName); got != tt.want {
+			t.Errorf("DetectFormat(%q) = %q, want %q", tt.fileName, got, tt.want)
+		}
+	}
+}
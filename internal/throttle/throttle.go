@@ -0,0 +1,236 @@
+package throttle
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Class separates streaming from downloads so streams can get a bigger share
+type Class int
+
+const (
+	Stream Class = iota
+	Download
+)
+
+// writeChunk is the largest write passed through at once, which keeps the
+// output smooth instead of bursty
+const writeChunk = 16 * 1024
+
+// Limits are in bytes per second. Zero disables a cap.
+type Limits struct {
+	Global        int64
+	PerConnection int64
+	PerFile       int64
+	// DownloadShare is the fraction (0-1] of the global and per-connection
+	// caps that downloads may use. Streams always get the full cap.
+	DownloadShare float64
+}
+
+// Shaper hands out throttled writers and keeps every bucket in sync with the
+// current limits, so they can be changed at runtime
+type Shaper struct {
+	mu        sync.Mutex
+	limits    Limits
+	global    *Bucket
+	downloads *Bucket
+	files     map[string]*fileBucket
+	conns     map[*Bucket]Class
+}
+
+type fileBucket struct {
+	bucket *Bucket
+	refs   int
+}
+
+func New(limits Limits) *Shaper {
+	s := &Shaper{
+		global:    NewBucket(0),
+		downloads: NewBucket(0),
+		files:     make(map[string]*fileBucket),
+		conns:     make(map[*Bucket]Class),
+	}
+	s.SetLimits(limits)
+	return s
+}
+
+// SetLimits applies new limits to all current and future connections
+func (s *Shaper) SetLimits(limits Limits) {
+	if limits.DownloadShare <= 0 || limits.DownloadShare > 1 {
+		limits.DownloadShare = 1
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.limits = limits
+	s.global.SetRate(limits.Global)
+	s.downloads.SetRate(share(limits.Global, limits.DownloadShare))
+	for _, fb := range s.files {
+		fb.bucket.SetRate(limits.PerFile)
+	}
+	for bucket, class := range s.conns {
+		bucket.SetRate(s.connRate(class))
+	}
+}
+
+// Limits returns the limits currently in effect
+func (s *Shaper) Limits() Limits {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.limits
+}
+
+func (s *Shaper) connRate(class Class) int64 {
+	if class == Download {
+		return share(s.limits.PerConnection, s.limits.DownloadShare)
+	}
+	return s.limits.PerConnection
+}
+
+func share(rate int64, fraction float64) int64 {
+	if rate == 0 {
+		return 0
+	}
+	if scaled := int64(float64(rate) * fraction); scaled > 0 {
+		return scaled
+	}
+	return 1
+}
+
+// Writer wraps w for one connection serving fileID. The returned function
+// must be called when the connection is done.
+func (s *Shaper) Writer(ctx context.Context, w io.Writer, fileID string, class Class) (*Writer, func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conn := NewBucket(s.connRate(class))
+	s.conns[conn] = class
+
+	fb, ok := s.files[fileID]
+	if !ok {
+		fb = &fileBucket{bucket: NewBucket(s.limits.PerFile)}
+		s.files[fileID] = fb
+	}
+	fb.refs++
+
+	buckets := []*Bucket{s.global, fb.bucket, conn}
+	if class == Download {
+		buckets = append(buckets, s.downloads)
+	}
+
+	release := func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.conns, conn)
+		if fb.refs--; fb.refs == 0 {
+			delete(s.files, fileID)
+		}
+	}
+	return &Writer{ctx: ctx, w: w, buckets: buckets}, release
+}
+
+// Writer is an io.Writer that waits for tokens from every bucket before
+// passing data through
+type Writer struct {
+	ctx     context.Context
+	w       io.Writer
+	buckets []*Bucket
+}
+
+func (tw *Writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if n > writeChunk {
+			n = writeChunk
+		}
+
+		var wait time.Duration
+		for _, bucket := range tw.buckets {
+			if d := bucket.reserve(n); d > wait {
+				wait = d
+			}
+		}
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-tw.ctx.Done():
+				timer.Stop()
+				return written, tw.ctx.Err()
+			}
+		}
+
+		m, err := tw.w.Write(p[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Bucket is a token bucket refilled at rate bytes per second. Reservations
+// may drive it negative; the caller then waits until the debt is repaid.
+type Bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func NewBucket(rate int64) *Bucket {
+	b := &Bucket{last: time.Now()}
+	b.SetRate(rate)
+	return b
+}
+
+// SetRate changes the refill rate; zero means unlimited
+func (b *Bucket) SetRate(rate int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refill(time.Now())
+	b.rate = float64(rate)
+	// Allow a quarter second of burst, but at least one write chunk
+	b.burst = b.rate / 4
+	if b.burst < writeChunk {
+		b.burst = writeChunk
+	}
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+// reserve takes n tokens and returns how long to wait before sending them
+func (b *Bucket) reserve(n int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.rate == 0 {
+		return 0
+	}
+
+	now := time.Now()
+	b.refill(now)
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+func (b *Bucket) refill(now time.Time) {
+	if b.rate > 0 {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+}
@@ -0,0 +1,124 @@
+package throttle
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestBucketReserve(t *testing.T) {
+	b := NewBucket(64 * 1024)
+
+	// The bucket starts empty, so the first chunk waits for its tokens
+	if wait := b.reserve(16 * 1024); wait < 240*time.Millisecond || wait > 250*time.Millisecond {
+		t.Errorf("first reserve waits %v, want about 250ms", wait)
+	}
+	// Debt adds up across reservations
+	if wait := b.reserve(16 * 1024); wait < 490*time.Millisecond || wait > 500*time.Millisecond {
+		t.Errorf("second reserve waits %v, want about 500ms", wait)
+	}
+
+	b.SetRate(0)
+	if wait := b.reserve(1 << 30); wait != 0 {
+		t.Errorf("unlimited bucket waits %v", wait)
+	}
+}
+
+func TestBucketBurstIsCapped(t *testing.T) {
+	b := NewBucket(1024 * 1024)
+	b.last = time.Now().Add(-time.Minute)
+
+	// An idle minute only banks a quarter second of tokens
+	if wait := b.reserve(256 * 1024); wait != 0 {
+		t.Errorf("reserve within the burst waits %v", wait)
+	}
+	if wait := b.reserve(256 * 1024); wait < 240*time.Millisecond {
+		t.Errorf("reserve past the burst waits %v, want about 250ms", wait)
+	}
+}
+
+func TestWriterRate(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits Limits
+		class  Class
+		want   time.Duration
+	}{
+		{"per connection", Limits{PerConnection: 256 * 1024}, Stream, 250 * time.Millisecond},
+		{"download share", Limits{PerConnection: 512 * 1024, DownloadShare: 0.5}, Download, 250 * time.Millisecond},
+		{"global", Limits{Global: 256 * 1024, PerConnection: 1024 * 1024}, Stream, 250 * time.Millisecond},
+		{"per file", Limits{PerFile: 256 * 1024}, Download, 250 * time.Millisecond},
+		{"unlimited", Limits{}, Stream, 0},
+	}
+
+	data := make([]byte, 64*1024)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := New(tt.limits)
+			var out bytes.Buffer
+			w, release := s.Writer(context.Background(), &out, "f", tt.class)
+			defer release()
+
+			start := time.Now()
+			if n, err := w.Write(data); n != len(data) || err != nil {
+				t.Fatalf("Write() = %d, %v", n, err)
+			}
+			elapsed := time.Since(start)
+			if elapsed < tt.want*8/10 || elapsed > tt.want+150*time.Millisecond {
+				t.Errorf("writing 64 KiB took %v, want about %v", elapsed, tt.want)
+			}
+			if !bytes.Equal(out.Bytes(), data) {
+				t.Error("written bytes differ")
+			}
+		})
+	}
+}
+
+func TestWriterStopsWhenCancelled(t *testing.T) {
+	s := New(Limits{PerConnection: 16 * 1024})
+	ctx, cancel := context.WithCancel(context.Background())
+	w, release := s.Writer(ctx, io.Discard, "f", Stream)
+	defer release()
+
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	n, err := w.Write(make([]byte, 1024*1024))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Write() error = %v, want %v", err, context.Canceled)
+	}
+	if n >= 1024*1024 || time.Since(start) > time.Second {
+		t.Errorf("Write() wrote %d bytes in %v after being cancelled", n, time.Since(start))
+	}
+}
+
+func TestSetLimitsUpdatesConnections(t *testing.T) {
+	s := New(Limits{PerConnection: 1024, DownloadShare: 0.5, PerFile: 2048})
+	stream, releaseStream := s.Writer(context.Background(), io.Discard, "f", Stream)
+	download, releaseDownload := s.Writer(context.Background(), io.Discard, "f", Download)
+
+	s.SetLimits(Limits{PerConnection: 4096, DownloadShare: 0.25, PerFile: 8192})
+	if got := stream.buckets[2].rate; got != 4096 {
+		t.Errorf("stream connection rate = %v, want 4096", got)
+	}
+	if got := download.buckets[2].rate; got != 1024 {
+		t.Errorf("download connection rate = %v, want 1024", got)
+	}
+	if got := stream.buckets[1].rate; got != 8192 {
+		t.Errorf("file rate = %v, want 8192", got)
+	}
+	if stream.buckets[1] != download.buckets[1] {
+		t.Error("connections to the same file don't share a bucket")
+	}
+
+	releaseStream()
+	releaseDownload()
+	if len(s.files) != 0 || len(s.conns) != 0 {
+		t.Errorf("%d file and %d connection buckets left after release", len(s.files), len(s.conns))
+	}
+	if got := s.Limits().DownloadShare; got != 0.25 {
+		t.Errorf("Limits().DownloadShare = %v, want 0.25", got)
+	}
+}
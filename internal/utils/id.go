@@ -36,3 +36,13 @@ func GenerateID() (string, error) {
 	return id[:8], nil
 }
 
+
+// GenerateToken returns a random URL-safe token for links that must not be
+// guessable, such as playlists
+func GenerateToken() (string, error) {
+	bytes := make([]byte, 24)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestPBKDF2SHA256(t *testing.T) {
+	// Test vectors for PBKDF2-HMAC-SHA256
+	tests := []struct {
+		password, salt string
+		iterations     int
+		keyLen         int
+		want           string
+	}{
+		{"password", "salt", 1, 32, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+		{"password", "salt", 2, 32, "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43"},
+		{"password", "salt", 4096, 32, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+		{"passwd", "salt", 1, 64, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc" +
+			"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+	}
+
+	for _, tt := range tests {
+		got := hex.EncodeToString(pbkdf2SHA256([]byte(tt.password), []byte(tt.salt), tt.iterations, tt.keyLen))
+		if got != tt.want {
+			t.Errorf("pbkdf2SHA256(%q, %q, %d) = %s, want %s", tt.password, tt.salt, tt.iterations, got, tt.want)
+		}
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	hash, err := HashPassword("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "pbkdf2-sha256$100000$") {
+		t.Errorf("HashPassword() = %q", hash)
+	}
+	if other, _ := HashPassword("correct horse"); other == hash {
+		t.Error("HashPassword() reused a salt")
+	}
+
+	parts := strings.Split(hash, "$")
+	tests := []struct {
+		name     string
+		hash     string
+		password string
+		want     bool
+	}{
+		{"correct", hash, "correct horse", true},
+		{"wrong", hash, "correct horse ", false},
+		{"empty", hash, "", false},
+		{"fewer iterations", strings.Join([]string{parts[0], "99999", parts[2], parts[3]}, "$"), "correct horse", false},
+		{"zero iterations", strings.Join([]string{parts[0], "0", parts[2], parts[3]}, "$"), "correct horse", false},
+		{"other salt", strings.Join([]string{parts[0], parts[1], "AAAAAAAAAAAAAAAAAAAAAA", parts[3]}, "$"), "correct horse", false},
+		{"bad encoding", strings.Join([]string{parts[0], parts[1], parts[2], "!" + parts[3][1:]}, "$"), "correct horse", false},
+		{"other scheme", "bcrypt$" + strings.Join(parts[1:], "$"), "correct horse", false},
+		{"not a hash", "correct horse", "correct horse", false},
+	}
+
+	for _, tt := range tests {
+		if got := CheckPassword(tt.hash, tt.password); got != tt.want {
+			t.Errorf("%s: CheckPassword() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrSignatureMissing = errors.New("link is not signed")
+	ErrSignatureInvalid = errors.New("link signature is invalid")
+	ErrSignatureExpired = errors.New("link signature has expired")
+)
+
+// Sign returns the hex HMAC-SHA256 of the file ID and expiry. The same
+// signature is valid for the watch, stream and download links of a file.
+func Sign(secret, fileID string, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s:%d", fileID, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignedQuery returns the "?e=...&sig=..." suffix for a signed link
+func SignedQuery(secret, fileID string, expires time.Time) string {
+	e := expires.Unix()
+	return fmt.Sprintf("?e=%d&sig=%s", e, Sign(secret, fileID, e))
+}
+
+// VerifySignature checks the e and sig query parameters of a request
+func VerifySignature(secret, fileID string, query url.Values) error {
+	e, sig := query.Get("e"), query.Get("sig")
+	if e == "" && sig == "" {
+		return ErrSignatureMissing
+	}
+
+	expires, err := strconv.ParseInt(e, 10, 64)
+	if err != nil {
+		return ErrSignatureInvalid
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return ErrSignatureInvalid
+	}
+	want, _ := hex.DecodeString(Sign(secret, fileID, expires))
+	if !hmac.Equal(got, want) {
+		return ErrSignatureInvalid
+	}
+
+	if time.Now().Unix() > expires {
+		return ErrSignatureExpired
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerifySignature(t *testing.T) {
+	expires := time.Now().Add(time.Hour).Unix()
+	sig := Sign("secret", "abc", expires)
+	e := strconv.FormatInt(expires, 10)
+	past := time.Now().Add(-time.Hour).Unix()
+
+	tests := []struct {
+		name   string
+		fileID string
+		query  url.Values
+		want   error
+	}{
+		{"valid", "abc", url.Values{"e": {e}, "sig": {sig}}, nil},
+		{"missing", "abc", url.Values{}, ErrSignatureMissing},
+		{"other file", "abd", url.Values{"e": {e}, "sig": {sig}}, ErrSignatureInvalid},
+		{"extended expiry", "abc", url.Values{"e": {strconv.FormatInt(expires+1, 10)}, "sig": {sig}}, ErrSignatureInvalid},
+		{"tampered signature", "abc", url.Values{"e": {e}, "sig": {flipLast(sig)}}, ErrSignatureInvalid},
+		{"truncated signature", "abc", url.Values{"e": {e}, "sig": {sig[:32]}}, ErrSignatureInvalid},
+		{"signature not hex", "abc", url.Values{"e": {e}, "sig": {"zz" + sig[2:]}}, ErrSignatureInvalid},
+		{"expiry not a number", "abc", url.Values{"e": {"soon"}, "sig": {sig}}, ErrSignatureInvalid},
+		{"only expiry", "abc", url.Values{"e": {e}}, ErrSignatureInvalid},
+		{"other secret", "abc", url.Values{"e": {e}, "sig": {Sign("other", "abc", expires)}}, ErrSignatureInvalid},
+		{"expired", "abc", url.Values{"e": {strconv.FormatInt(past, 10)}, "sig": {Sign("secret", "abc", past)}}, ErrSignatureExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := VerifySignature("secret", tt.fileID, tt.query); !errors.Is(err, tt.want) {
+				t.Errorf("VerifySignature() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignedQuery(t *testing.T) {
+	query, err := url.ParseQuery(SignedQuery("secret", "abc", time.Now().Add(time.Minute))[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifySignature("secret", "abc", query); err != nil {
+		t.Errorf("VerifySignature() = %v for a fresh signed query", err)
+	}
+}
+
+// flipLast changes the last hex digit of a signature
+func flipLast(sig string) string {
+	last := "0"
+	if sig[len(sig)-1] == '0' {
+		last = "1"
+	}
+	return sig[:len(sig)-1] + last
+}